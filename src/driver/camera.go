@@ -0,0 +1,84 @@
+package main
+
+import "github.com/tinne26/mipix"
+import "github.com/tinne26/mipix/shaker"
+import "github.com/tinne26/mipix/tracker"
+import "github.com/tinne26/mipix/zoomer"
+
+// --- camera state resets ---
+
+// mipix keeps zoom speeds, tracking speeds and shake progress
+// across ResetCoordinates() and EndShake() calls, so each run
+// would start from wherever the previous one ended. The zoomer
+// and tracker here can snap to their targets instead, dropping
+// all their state, so runs with the same seed and inputs are
+// reproduced exactly.
+
+var _ zoomer.Zoomer = (*SnapZoomer)(nil)
+var _ tracker.Tracker = (*SnapTracker)(nil)
+
+// Behaves like the default zoomer.Quadratic, but can
+// jump to the target zoom instantly.
+type SnapZoomer struct {
+	zoomer.Quadratic
+	snap bool
+}
+
+// Makes the next update jump to the target zoom.
+func (self *SnapZoomer) Snap() {
+	self.snap = true
+}
+
+// Implements the [zoomer.Zoomer] interface.
+func (self *SnapZoomer) Update(currentZoom, targetZoom float64) float64 {
+	if !self.snap { return self.Quadratic.Update(currentZoom, targetZoom) }
+	self.snap = false
+	self.Quadratic.Reset()
+	// zooms are within a factor of 2 of each other, so the
+	// difference is exact and lands on the target exactly
+	return targetZoom - currentZoom
+}
+
+// Behaves like the default mipix tracker, a tracker.SpringTailer,
+// but can jump to the target instantly.
+type SnapTracker struct {
+	tailer tracker.SpringTailer
+	snap bool
+}
+
+func NewSnapTracker() *SnapTracker {
+	snapTracker := &SnapTracker{}
+	snapTracker.resetTailer()
+	return snapTracker
+}
+
+// Makes the next update jump to the target, also
+// dropping the previous tracking speed.
+func (self *SnapTracker) Snap() {
+	self.snap = true
+}
+
+// Implements the [tracker.Tracker] interface.
+func (self *SnapTracker) Update(currentX, currentY, targetX, targetY, prevSpeedX, prevSpeedY float64) (float64, float64) {
+	if !self.snap {
+		return self.tailer.Update(currentX, currentY, targetX, targetY, prevSpeedX, prevSpeedY)
+	}
+	self.snap = false
+	self.resetTailer()
+	return targetX - currentX, targetY - currentY
+}
+
+func (self *SnapTracker) resetTailer() {
+	self.tailer = tracker.SpringTailer{}
+	self.tailer.Spring.SetParameters(0.8, 2.4)
+	self.tailer.SetCatchUpParameters(0.9, 1.75)
+}
+
+// Stops the shakes on the given channels right away, and leaves
+// them as if they had never been started. EndShake(0) alone keeps
+// the shake progress, so the next StartShake() would skip its
+// fade in.
+func clearShakes(channels ...shaker.Channel) {
+	mipix.Camera().StartShake(0, channels...)
+	mipix.Camera().EndShake(0, channels...)
+}
@@ -0,0 +1,183 @@
+package main
+
+import ("image/color" ; "math" ; "math/rand/v2")
+import "github.com/hajimehoshi/ebiten/v2"
+import "github.com/tinne26/mipix"
+import "github.com/tinne26/mipix/utils"
+
+// --- bézier curves for the track ---
+
+// A track segment going upwards, from the origin point at
+// the bottom to the final point at the top. The x control
+// points match the start and end x's, and the y control
+// points are rolled so y always decreases along the curve.
+//
+// Since y is monotonic, the x for a given y can be found
+// by solving the cubic y(t) = y analytically. The road x
+// for each pixel line is precomputed on Reroll, so drawing
+// doesn't depend on the curve length or steepness.
+type Curve struct {
+	ox, oy, fx, fy float64 // start and end points
+	ocy, fcy float64 // bézier control point y's
+	lineXs []float64 // road center x for each pixel line, from the bottom
+	bottomLine int // y of the first pixel line in lineXs
+}
+
+func (self *Curve) Draw(canvas *ebiten.Image, clr color.Color) {
+	area := mipix.Camera().Area()
+	first := max(self.bottomLine - len(self.lineXs) + 1, area.Min.Y - 1)
+	last  := min(self.bottomLine, area.Max.Y)
+	for y := first; y <= last; y++ {
+		x := self.lineXs[self.bottomLine - y]
+		xl := int(math.Round(x - RoadWidth/2.0)) - area.Min.X
+		xr, yt := xl + RoadWidth, y - area.Min.Y
+		utils.FillOverRect(canvas, utils.Rect(xl, yt, xr, yt + 1), clr)
+	}
+}
+
+// Rolls a new curve starting at the given point, using the
+// given random source (see [TrackGenerator]).
+func (self *Curve) Reroll(rng *rand.Rand, ox, oy float64) {
+	self.ox, self.oy = ox, oy
+	self.fx = ox + 24.0*(rng.Float64() - 0.5)*2.0
+	self.fy = oy - (GameHeight + 2 + math.Floor((GameHeight/3.0)*rng.Float64()))
+	dy := self.oy - self.fy
+	self.ocy = self.oy - (dy*0.3 + dy*rng.Float64()*0.45)
+	self.fcy = self.fy + (dy*0.3 + dy*rng.Float64()*0.45)
+	self.computeLines()
+}
+
+// Precomputes the road x for each pixel line whose center
+// is within the curve's y range. Consecutive curves share
+// their end points, so they never leave gaps between them.
+func (self *Curve) computeLines() {
+	self.bottomLine = int(math.Floor(self.oy - 0.5))
+	topLine := int(math.Ceil(self.fy - 0.5))
+	self.lineXs = self.lineXs[ : 0]
+	for y := self.bottomLine; y >= topLine; y-- {
+		self.lineXs = append(self.lineXs, self.GetClosestX(float64(y) + 0.5))
+	}
+}
+
+// Returns the curve x at the given y. Out of range
+// y's return the x of the closest end point.
+func (self *Curve) GetClosestX(refY float64) float64 {
+	x, _ := self.eval(self.solveT(refY))
+	return x
+}
+
+func (self *Curve) ContainsY(y float64) bool {
+	return y >= self.fy && y <= self.oy
+}
+
+// Returns the t in [0, 1] where the curve reaches the given y.
+func (self *Curve) solveT(y float64) float64 {
+	if y >= self.oy { return 0.0 }
+	if y <= self.fy { return 1.0 }
+
+	// y(t) = a*t^3 + b*t^2 + c*t + d, in power basis
+	p0, p1, p2, p3 := self.oy, self.ocy, self.fcy, self.fy
+	a := -p0 + 3.0*p1 - 3.0*p2 + p3
+	b := 3.0*p0 - 6.0*p1 + 3.0*p2
+	c := 3.0*(p1 - p0)
+	d := p0 - y
+	var buffer [3]float64
+	roots := solveCubic(a, b, c, d, buffer[ : 0])
+
+	// pick the root within range, with some tolerance for
+	// precision issues, and fall back to bisection if needed
+	const Tolerance = 1e-7
+	for _, t := range roots {
+		if t < -Tolerance || t > 1.0 + Tolerance { continue }
+		for range 2 { // newton steps to polish near-repeated roots
+			f, df := ((a*t + b)*t + c)*t + d, (3.0*a*t + 2.0*b)*t + c
+			if df == 0 { break }
+			t -= f/df
+		}
+		if t >= -Tolerance && t <= 1.0 + Tolerance {
+			return min(max(t, 0.0), 1.0)
+		}
+	}
+	return self.bisectT(y)
+}
+
+// Slow but safe fallback for solveT, relying on y being
+// monotonically decreasing in t.
+func (self *Curve) bisectT(y float64) float64 {
+	lo, hi := 0.0, 1.0
+	for range 64 {
+		mid := (lo + hi)/2.0
+		_, my := self.eval(mid)
+		if my > y { lo = mid } else { hi = mid }
+	}
+	return (lo + hi)/2.0
+}
+
+func (self *Curve) eval(t float64) (float64, float64) {
+	oc1x , oc1y  := self.ox, lerp(self.oy, self.ocy, t) // origin to control 1
+	c2fx , c2fy  := self.fx, lerp(self.fcy, self.fy, t)  // control 2 to end
+	c1c2x, c1c2y := lerp2(self.ox, self.ocy, self.fx, self.fcy, t) // control 1 to control 2
+	iox  , ioy   := lerp2(oc1x, oc1y, c1c2x, c1c2y, t) // first interpolation from origin
+	ifx  , ify   := lerp2(c1c2x, c1c2y, c2fx, c2fy, t) // second interpolation to end
+	return lerp2(iox, ioy, ifx, ify, t) // cubic interpolation
+}
+func lerp2(ax, ay, bx, by float64, t float64) (float64, float64) {
+	return lerp(ax, bx, t), lerp(ay, by, t)
+}
+func lerp(a, b float64, t float64) float64 {
+	return a + t*(b - a)
+}
+
+// --- polynomial roots ---
+
+// Appends the real roots of a*x^3 + b*x^2 + c*x + d = 0 to the
+// given slice, in ascending order. Degenerate cubics are solved
+// as quadratics or linear equations, and constant equations
+// have no roots.
+func solveCubic(a, b, c, d float64, roots []float64) []float64 {
+	const Epsilon = 1e-12
+	scale := max(math.Abs(a), math.Abs(b), math.Abs(c), math.Abs(d))
+	if scale == 0 { return roots }
+	if math.Abs(a) <= Epsilon*scale { return solveQuadratic(b, c, d, roots) }
+
+	// depressed cubic t^3 + p*t + q = 0, with x = t - b/(3a)
+	b, c, d = b/a, c/a, d/a
+	shift := b/3.0
+	p := c - b*b/3.0
+	q := 2.0*b*b*b/27.0 - b*c/3.0 + d
+	disc := q*q/4.0 + p*p*p/27.0
+	switch {
+	case disc > Epsilon: // one real root
+		sqrtDisc := math.Sqrt(disc)
+		roots = append(roots, math.Cbrt(-q/2.0 + sqrtDisc) + math.Cbrt(-q/2.0 - sqrtDisc) - shift)
+	case disc < -Epsilon: // three distinct real roots
+		r := 2.0*math.Sqrt(-p/3.0)
+		phi := math.Acos(min(max(3.0*q/(p*r), -1.0), 1.0))/3.0
+		x0 := r*math.Cos(phi) - shift
+		x1 := r*math.Cos(phi - 2.0*math.Pi/3.0) - shift
+		x2 := r*math.Cos(phi - 4.0*math.Pi/3.0) - shift
+		roots = append(roots, min(x0, x1, x2), x0 + x1 + x2 - min(x0, x1, x2) - max(x0, x1, x2), max(x0, x1, x2))
+	default: // repeated roots
+		u := math.Cbrt(-q/2.0)
+		roots = append(roots, min(2.0*u, -u) - shift, max(2.0*u, -u) - shift)
+	}
+	return roots
+}
+
+// Appends the real roots of a*x^2 + b*x + c = 0 to the given
+// slice, in ascending order.
+func solveQuadratic(a, b, c float64, roots []float64) []float64 {
+	const Epsilon = 1e-12
+	scale := max(math.Abs(a), math.Abs(b), math.Abs(c))
+	if scale == 0 { return roots }
+	if math.Abs(a) <= Epsilon*scale {
+		if b == 0 { return roots }
+		return append(roots, -c/b)
+	}
+	disc := b*b - 4.0*a*c
+	if disc < 0 { return roots }
+	q := -0.5*(b + math.Copysign(math.Sqrt(disc), b)) // avoids cancellation
+	if q == 0 { return append(roots, 0, 0) } // b and c are both zero
+	x0, x1 := q/a, c/q
+	return append(roots, min(x0, x1), max(x0, x1))
+}
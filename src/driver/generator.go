@@ -0,0 +1,58 @@
+package main
+
+import ("math/rand/v2" ; "strconv")
+
+// Random streams derived from each seed. Tracks, traffic and
+// shakes use separate streams, so changes in how often one
+// of them rolls don't alter the others.
+const (
+	StreamTrack uint64 = iota + 1
+	StreamTraffic
+	StreamShakes
+)
+
+// --- track generator ---
+
+// The source of randomness for everything that needs to be
+// reproducible: track curves, traffic and camera shakes. The
+// same seed and player inputs always lead to the same run.
+type TrackGenerator struct {
+	seed uint64
+	trackSource, trafficSource, shakesSource rand.PCG
+	track, traffic, shakes *rand.Rand
+}
+
+func NewTrackGenerator(seed uint64) *TrackGenerator {
+	gen := &TrackGenerator{}
+	gen.track = rand.New(&gen.trackSource)
+	gen.traffic = rand.New(&gen.trafficSource)
+	gen.shakes = rand.New(&gen.shakesSource)
+	gen.Reseed(seed)
+	return gen
+}
+
+// Parses a seed as given on the command line or on the URL.
+func ParseSeed(text string) (uint64, error) {
+	return strconv.ParseUint(text, 10, 64)
+}
+
+func (self *TrackGenerator) Seed() uint64 {
+	return self.seed
+}
+
+// Sets a new seed and restarts all the streams.
+func (self *TrackGenerator) Reseed(seed uint64) {
+	self.seed = seed
+	self.Reset()
+}
+
+// Restarts all the streams from the current seed.
+func (self *TrackGenerator) Reset() {
+	self.trackSource.Seed(self.seed, StreamTrack)
+	self.trafficSource.Seed(self.seed, StreamTraffic)
+	self.shakesSource.Seed(self.seed, StreamShakes)
+}
+
+func (self *TrackGenerator) Track() *rand.Rand { return self.track }
+func (self *TrackGenerator) Traffic() *rand.Rand { return self.traffic }
+func (self *TrackGenerator) Shakes() *rand.Rand { return self.shakes }
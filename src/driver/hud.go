@@ -0,0 +1,100 @@
+package main
+
+import ("image/color" ; "math" ; "strconv" ; "strings")
+import "github.com/tinne26/mipix"
+import "github.com/tinne26/mipix/utils"
+
+var HUDTextRGB, HUDShadeRGB = utils.RGB(250, 246, 246), utils.RGBA(0, 0, 0, 144)
+
+// --- tiny font for the HUD ---
+
+// 3x5 glyph rows from top to bottom, separated by spaces.
+var hudGlyphs = map[rune]string{
+	'0': "### #.# #.# #.# ###", '1': ".#. ##. .#. .#. ###", '2': "##. ..# .#. #.. ###",
+	'3': "##. ..# .#. ..# ##.", '4': "#.# #.# ### ..# ..#", '5': "### #.. ##. ..# ##.",
+	'6': ".## #.. ### #.# ###", '7': "### ..# .#. .#. .#.", '8': "### #.# ### #.# ###",
+	'9': "### #.# ### ..# ##.", 'x': "... #.# .#. #.# ...", '-': "... ... ### ... ...",
+	' ': "... ... ... ... ...", '%': "#.# ..# .#. #.. #.#", 'D': "##. #.# #.# #.# ##.",
+	'R': "##. #.# ##. #.# #.#", 'S': ".## #.. .#. ..# ##.", 'P': "##. #.# ##. #.. #..",
+}
+
+const HUDGlyphAdvance, HUDLineHeight = 4, 7
+
+// Draws the text with its top-left corner at the given
+// coordinates. Unsupported characters are skipped.
+func drawHUDText(ui *mipix.Offscreen, text string, x, y int, clr color.Color) {
+	for _, char := range text {
+		rows := strings.Fields(hudGlyphs[char])
+		for row, pixels := range rows {
+			for col, pixel := range pixels {
+				if pixel != '#' { continue }
+				ui.CoatRect(utils.Rect(x + col, y + row, x + col + 1, y + row + 1), clr)
+			}
+		}
+		x += HUDGlyphAdvance
+	}
+}
+
+func hudTextWidth(text string) int {
+	return len(text)*HUDGlyphAdvance - 1
+}
+
+// --- hud ---
+
+// Draws the steering indicator, with the score at its left,
+// the multiplier at its right and a bar above it showing how
+// long the vehicle can stay off road before the run ends.
+func (self *Game) drawHUD() {
+	wcx, wcy := GameWidth/2, GameHeight - GameHeight/8
+	wheelBarRect := utils.Rect(wcx - 8, wcy - 1, wcx + 8, wcy + 1)
+	self.ui.CoatRect(wheelBarRect, WheelBarRGB)
+	px := wcx + int(math.Round(self.wheel*8.0))
+	wheelPinRect := utils.Rect(px - 1, wcy - 2, px + 1, wcy + 2)
+	self.ui.CoatRect(wheelPinRect, WheelPinRGB)
+
+	points := strconv.Itoa(self.score.Points())
+	drawHUDText(self.ui, points, wcx - 12 - hudTextWidth(points), wcy - 2, HUDTextRGB)
+	multiplier := "x" + strconv.Itoa(int(self.score.Multiplier()))
+	drawHUDText(self.ui, multiplier, wcx + 12, wcy - 2, HUDTextRGB)
+	if ratio := self.score.OffRoadRatio(); ratio > 0 {
+		width := int(math.Ceil(16.0*(1.0 - ratio)))
+		self.ui.CoatRect(utils.Rect(wcx - 8, wcy - 5, wcx - 8 + width, wcy - 4), VehicleRGB)
+	}
+}
+
+// Draws the run stats on a shaded strip at the top-left corner:
+// distance (D), time on road (R), best streak in seconds (S)
+// and penalties (P).
+func (self *Game) drawStats() {
+	text := "D" + strconv.Itoa(int(self.score.Distance())) +
+		" R" + strconv.Itoa(int(math.Round(self.score.OnRoadRatio()*100.0))) + "%" +
+		" S" + strconv.Itoa(int(self.score.BestStreakTicks()/60.0)) +
+		" P" + strconv.Itoa(self.score.Penalties())
+	self.ui.CoatRect(utils.Rect(0, 0, hudTextWidth(text) + 4, HUDLineHeight + 2), HUDShadeRGB)
+	drawHUDText(self.ui, text, 2, 2, HUDTextRGB)
+}
+
+// Draws the high score table over a shaded screen, with
+// the entry for the last run highlighted. Each entry shows
+// the points and the distance (D).
+func (self *Game) drawHighScores() {
+	self.ui.Coat(HUDShadeRGB)
+	entries := self.highScores.Entries()
+	pointsX := hudTextWidth("0 ") + 1
+	distanceX := pointsX + hudTextWidth("00000 ") + 1
+	width := distanceX + hudTextWidth("D00000")
+	x := (GameWidth - width)/2
+	y := (GameHeight - HighScoreCount*HUDLineHeight)/2
+	for i := range HighScoreCount {
+		clr := HUDTextRGB
+		if i == self.lastRank { clr = VehicleRGB }
+		ey := y + i*HUDLineHeight
+		drawHUDText(self.ui, strconv.Itoa(i + 1), x, ey, clr)
+		if i >= len(entries) {
+			drawHUDText(self.ui, "-", x + pointsX, ey, clr)
+			continue
+		}
+		drawHUDText(self.ui, strconv.Itoa(entries[i].Points), x + pointsX, ey, clr)
+		drawHUDText(self.ui, "D" + strconv.Itoa(entries[i].Distance), x + distanceX, ey, clr)
+	}
+}
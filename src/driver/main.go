@@ -1,6 +1,6 @@
 package main
 
-import ("image/color" ; "math" ; "math/rand/v2")
+import ("flag" ; "math" ; "math/rand/v2")
 import "github.com/hajimehoshi/ebiten/v2"
 import "github.com/hajimehoshi/ebiten/v2/inpututil"
 import "github.com/tinne26/mipix"
@@ -8,97 +8,41 @@ import "github.com/tinne26/mipix/shaker"
 import "github.com/tinne26/mipix/utils"
 
 // A driving game example showcasing basic structure,
-// camera tracking, zooms and shakes.
+// camera tracking, zooms and shakes. Points are earned by
+// staying on the road and avoiding obstacles and rivals,
+// and the run ends after being off road for too long.
 
 const GameWidth, GameHeight, RoadWidth = 128, 72, 16
 var BackRGB, RoadRGB = utils.RGB(126, 224, 129), utils.RGB(25, 21, 22)
 var WheelBarRGB, WheelPinRGB = utils.RGB(250, 246, 246), utils.RGB(8, 103, 136)
 var VehicleRGB = utils.RGB(255, 22, 84)
 
-// --- bézier curves for the track, you can ignore this ---
+// Vehicle speed parameters. Speeds are relative to the cruise
+// speed, which moves the vehicle 0.23 pixels up per tick when
+// going straight.
+const (
+	VehicleStartSpeed = 1.0
+	VehicleTopSpeed = 1.8
+	VehicleAcceleration = 0.012 // per tick, while the throttle is pressed
+	VehicleBraking = 0.03 // per tick, while the brake is pressed
+	VehicleDrag = 0.004 // fraction of the speed lost per tick
+)
 
-type Curve struct {
-	ox, oy, fx, fy float64 // start and end points
-	ocy, fcy float64 // bézier control point y's
-}
-
-func (self *Curve) Draw(canvas *ebiten.Image, clr color.Color) {
-	area := mipix.Camera().Area()
-	self.eachYLine(func(x float64, baseY int) {
-		if baseY + 1 < area.Min.Y || baseY > area.Max.Y { return }
-		xl := int(math.Round(x - RoadWidth/2.0)) - area.Min.X
-		xr, yt := xl + RoadWidth, baseY - area.Min.Y
-		utils.FillOverRect(canvas, utils.Rect(xl, yt, xr, yt + 1), clr)
-	})
-}
-
-func (self *Curve) Reroll(ox, oy float64) {
-	self.ox, self.oy = ox, oy
-	self.fx = ox + 24.0*(rand.Float64() - 0.5)*2.0
-	self.fy = oy - (GameHeight + 2 + math.Floor((GameHeight/3.0)*rand.Float64()))
-	dy := self.oy - self.fy
-	self.ocy = self.oy - (dy*0.3 + dy*rand.Float64()*0.45)
-	self.fcy = self.fy + (dy*0.3 + dy*rand.Float64()*0.45)
-}
+// Speed factors kept after crashing into a rock or a rival,
+// and wheel swerve after driving over an oil slick.
+const RockImpactSlowdown, RivalImpactSlowdown, OilSwerve = 0.35, 0.5, 0.6
 
-func (self *Curve) GetClosestX(refY float64) float64 {
-	const TimeStep = 0.003
-	var bestX, minDist float64 = 0, 6666.0
-	for t := 0.0; t < 0.99999 + TimeStep; t += TimeStep {
-		x, y := self.eval(t) // just brute forcing
-		dist := math.Abs(y - refY)
-		if dist < minDist {
-			bestX, minDist = x, dist
-		}
-	}
-	return bestX
-}
-
-func (self *Curve) ContainsY(y float64) bool {
-	return y >= self.fy && y <= self.oy
-}
-
-// evaluates the curve brute forcing it and yields the x
-// values of the closest y's to .5 for each pixel line
-func (self *Curve) eachYLine(yield func(float64, int)) {
-	const TimeStep = 0.002
-	aBaseY, aX, aBestDist := self.oy + 0.0, self.ox, 0.5
-	bBaseY, bX, bBestDist := self.oy - 1.0, self.ox, 1.5
-	for t := TimeStep; t < 0.99999 + TimeStep; t += TimeStep {
-		x, y := self.eval(t)
-		aDist := math.Abs((aBaseY - 0.5) - y)
-		bDist := math.Abs((bBaseY - 0.5) - y)
-		if aDist <= aBestDist {
-			aX, aBestDist = x, aDist
-			bX, bBestDist = x, bDist
-		} else {
-			yield(aX, int(aBaseY))
-			aBaseY, bBaseY = bBaseY, bBaseY - 1.0
-			aX, aBestDist = bX, bBestDist
-			if bDist <= bBestDist {
-				bX, bBestDist = x, bDist
-			} else {
-				panic("timestep too coarse")
-			}
-		}
-	}
-	yield(aX, int(aBaseY))
-}
+// Shake channels. Off-road shaking is started and ended
+// continuously, so impacts need their own channel.
+const (
+	ChanOffRoad shaker.Channel = iota
+	ChanImpact
+)
 
-func (self *Curve) eval(t float64) (float64, float64) {
-	oc1x , oc1y  := self.ox, lerp(self.oy, self.ocy, t) // origin to control 1
-	c2fx , c2fy  := self.fx, lerp(self.fcy, self.fy, t)  // control 2 to end
-	c1c2x, c1c2y := lerp2(self.ox, self.ocy, self.fx, self.fcy, t) // control 1 to control 2
-	iox  , ioy   := lerp2(oc1x, oc1y, c1c2x, c1c2y, t) // first interpolation from origin
-	ifx  , ify   := lerp2(c1c2x, c1c2y, c2fx, c2fy, t) // second interpolation to end
-	return lerp2(iox, ioy, ifx, ify, t) // cubic interpolation
-}
-func lerp2(ax, ay, bx, by float64, t float64) (float64, float64) {
-	return lerp(ax, bx, t), lerp(ay, by, t)
-}
-func lerp(a, b float64, t float64) float64 {
-	return a + t*(b - a)
-}
+// Camera zoom levels at zero and top speed. The zoom target
+// follows the speed continuously, and mipix smooths the
+// transitions between targets.
+const ZoomSlow, ZoomFast = 1.4, 1.0
 
 // --- main game logic ---
 
@@ -106,7 +50,65 @@ type Game struct {
 	ui *mipix.Offscreen
 	vehicleCX, vehicleCY float64 // center X, centerY
 	wheel float64
+	speed float64
 	track [2]Curve
+	traffic Traffic
+	gen *TrackGenerator
+	fixedSeed bool // if false, each run rolls a new seed
+	offRoadShaker *SpringShaker
+	impactShaker *RandomShaker
+	zoomer *SnapZoomer
+	tracker *SnapTracker
+	score Score
+	highScores *HighScores
+	highScoresName string // storage entry name
+	gameOver bool
+	lastRank int // high score rank of the last run, -1 if none
+	message string // high score storage and seed errors
+}
+
+// Places the vehicle and camera at the start of a new track,
+// and resets the score. With a fixed seed, the track, traffic
+// and shakes are the same on every run.
+func (self *Game) Restart() {
+	if self.fixedSeed {
+		self.gen.Reset()
+	} else {
+		self.gen.Reseed(rand.Uint64())
+	}
+	self.offRoadShaker.Reset()
+	self.impactShaker.Reset()
+
+	self.vehicleCX, self.vehicleCY = 0, 0
+	self.wheel = 0
+	self.speed = VehicleStartSpeed
+	self.track[0].Reroll(self.gen.Track(), 0, GameHeight/5.0)
+	self.track[0].fx = 0
+	self.track[0].computeLines()
+	self.track[1].Reroll(self.gen.Track(), 0, self.track[0].fy)
+	self.traffic.Clear()
+	self.traffic.Spawn(self.gen.Traffic(), &self.track[1]) // keep the start clear
+	self.score = Score{}
+	self.gameOver, self.lastRank = false, -1
+
+	// start the camera from scratch, not from the previous run
+	clearShakes(ChanOffRoad, ChanImpact)
+	mipix.Camera().Zoom(speedZoom(self.speed))
+	self.zoomer.Snap()
+	mipix.Camera().ResetCoordinates(self.vehicleCX, self.vehicleCY - GameHeight/6)
+	self.tracker.Snap()
+}
+
+// Ends the run and adds it to the high scores.
+func (self *Game) endRun() {
+	self.gameOver = true
+	mipix.Camera().EndShake(20, ChanOffRoad)
+	self.lastRank = self.highScores.Add(&self.score)
+	if self.lastRank == -1 { return }
+	err := self.highScores.Save(self.highScoresName)
+	if err != nil {
+		self.message = "High score save failed: " + err.Error()
+	}
 }
 
 func (self *Game) Update() error {
@@ -115,6 +117,14 @@ func (self *Game) Update() error {
 		ebiten.SetFullscreen(!ebiten.IsFullscreen())
 	}
 
+	// restart after the run is over
+	if self.gameOver {
+		if inpututil.IsKeyJustPressed(ebiten.KeyEnter) || inpututil.IsKeyJustPressed(ebiten.KeySpace) {
+			self.Restart()
+		}
+		return nil
+	}
+
 	// turn left / right
 	if ebiten.IsKeyPressed(ebiten.KeyA) || ebiten.IsKeyPressed(ebiten.KeyArrowLeft) {
 		self.wheel = max(self.wheel - 0.01, -1.0)
@@ -122,11 +132,22 @@ func (self *Game) Update() error {
 		self.wheel = min(self.wheel + 0.01,  1.0)
 	}
 
+	// accelerate / brake
+	if ebiten.IsKeyPressed(ebiten.KeyW) || ebiten.IsKeyPressed(ebiten.KeyArrowUp) {
+		self.speed += VehicleAcceleration
+	}
+	if ebiten.IsKeyPressed(ebiten.KeyS) || ebiten.IsKeyPressed(ebiten.KeyArrowDown) {
+		self.speed -= VehicleBraking
+	}
+	self.speed -= self.speed*VehicleDrag
+	self.speed = min(max(self.speed, 0.0), VehicleTopSpeed)
+
 	// update vehicle position
 	degrees := 90.0 - self.wheel*45.0
 	dy, dx  := math.Sincos(degrees*math.Pi/180.0)
-	self.vehicleCX += dx*0.4
-	self.vehicleCY -= dy*0.23
+	dx, dy = dx*0.4*self.speed, dy*0.23*self.speed
+	self.vehicleCX += dx
+	self.vehicleCY -= dy
 
 	// notify new camera position
 	mipix.Camera().NotifyCoordinates(self.vehicleCX, self.vehicleCY - GameHeight/6)
@@ -135,9 +156,35 @@ func (self *Game) Update() error {
 	t := 0 // active track index
 	if self.track[1].ContainsY(self.vehicleCY) { t = 1 }
 	xOffset := math.Abs(self.track[t].GetClosestX(self.vehicleCY) - self.vehicleCX)
-	switch xOffset + 2.5 > RoadWidth/2.0 {
-	case true  : mipix.Camera().StartShake(20) // going off road
-	case false : mipix.Camera().EndShake(20)   // staying within road
+	onRoad := xOffset + 2.5 <= RoadWidth/2.0
+	switch onRoad {
+	case false : mipix.Camera().StartShake(20, ChanOffRoad) // going off road
+	case true  : mipix.Camera().EndShake(20, ChanOffRoad)   // staying within road
+	}
+
+	// crash into obstacles and rivals
+	switch self.traffic.Collide(self.vehicleCX, self.vehicleCY) {
+	case ImpactRock:
+		self.speed *= RockImpactSlowdown
+		self.score.Penalize()
+		mipix.Camera().TriggerShake(0, 20, 40, ChanImpact)
+	case ImpactOil:
+		if self.wheel < 0 {
+			self.wheel = max(self.wheel - OilSwerve, -1.0)
+		} else {
+			self.wheel = min(self.wheel + OilSwerve,  1.0)
+		}
+		mipix.Camera().TriggerShake(0, 8, 24, ChanImpact)
+	case ImpactRival:
+		self.speed *= RivalImpactSlowdown
+		self.score.Penalize()
+		mipix.Camera().TriggerShake(0, 14, 36, ChanImpact)
+	}
+
+	// update score, ending the run if off road for too long
+	if !self.score.Update(math.Hypot(dx, dy), onRoad) {
+		self.endRun()
+		return nil
 	}
 
 	// reroll tracks as needed
@@ -145,17 +192,19 @@ func (self *Game) Update() error {
 	area := mipix.Camera().Area()
 	cutoffY := float64(area.Max.Y) + float64(area.Dy())*(currentZoom - 1.0)
 	if self.track[0].fy > cutoffY {
-		self.track[0].Reroll(self.track[1].fx, self.track[1].fy)
+		self.track[0].Reroll(self.gen.Track(), self.track[1].fx, self.track[1].fy)
+		self.traffic.Spawn(self.gen.Traffic(), &self.track[0])
 	} else if self.track[1].fy > cutoffY {
-		self.track[1].Reroll(self.track[0].fx, self.track[0].fy)
+		self.track[1].Reroll(self.gen.Track(), self.track[0].fx, self.track[0].fy)
+		self.traffic.Spawn(self.gen.Traffic(), &self.track[1])
 	}
+	self.traffic.Update(&self.track, cutoffY)
 
-	// change zoom every once in a while
-	if mipix.Tick().Now() % (60*10) == 0 {
-		switch targetZoom {
-		case 1.0 : mipix.Camera().Zoom(1.4)
-		default  : mipix.Camera().Zoom(1.0)
-		}
+	// zoom out when going faster, skipping negligible
+	// changes so the zoomer isn't retargeted every tick
+	newZoom := speedZoom(self.speed)
+	if math.Abs(newZoom - targetZoom) >= 0.01 {
+		mipix.Camera().Zoom(newZoom)
 	}
 
 	return nil
@@ -167,29 +216,45 @@ func (self *Game) Draw(canvas *ebiten.Image) {
 	self.track[0].Draw(canvas, RoadRGB)
 	self.track[1].Draw(canvas, RoadRGB)
 
-	// draw car at high resolution coordinates
+	// draw traffic and car at high resolution coordinates
+	mipix.QueueHiResDraw(self.traffic.DrawHiRes)
 	mipix.QueueHiResDraw(self.DrawCarHiRes)
 	
-	// draw wheel/steering indicator
+	// draw wheel/steering indicator and score, or
+	// the high scores if the run is over, and run stats
 	self.ui.Clear()
-	wcx, wcy := GameWidth/2, GameHeight - GameHeight/8
-	wheelBarRect := utils.Rect(wcx - 8, wcy - 1, wcx + 8, wcy + 1)
-	self.ui.CoatRect(wheelBarRect, WheelBarRGB)
-	px := wcx + int(math.Round(self.wheel*8.0))
-	wheelPinRect := utils.Rect(px - 1, wcy - 2, px + 1, wcy + 2)
-	self.ui.CoatRect(wheelPinRect, WheelPinRGB)
+	if self.gameOver {
+		self.drawHighScores()
+	} else {
+		self.drawHUD()
+	}
+	self.drawStats()
 	mipix.QueueHiResDraw(func(_, hiResCanvas *ebiten.Image) {
 		self.ui.Project(hiResCanvas)
 	})
 
-	// print instructions and actions
+	// print instructions, actions, seed and speed
 	mipix.Debug().Drawf("[LEFT/RIGHT] Steer")
+	mipix.Debug().Drawf("[UP/DOWN] Throttle/brake")
 	mipix.Debug().Drawf("[F] Fullscreen")
+	if self.gameOver {
+		mipix.Debug().Drawf("[ENTER] Restart")
+	}
+	mipix.Debug().Drawf("Seed: %d", self.gen.Seed())
+	mipix.Debug().Drawf("Speed: %.2f", self.speed)
+	if self.message != "" {
+		mipix.Debug().Drawf("%s", self.message)
+	}
+}
+
+// Returns the zoom level for the given vehicle speed.
+func speedZoom(speed float64) float64 {
+	return lerp(ZoomSlow, ZoomFast, speed/VehicleTopSpeed)
 }
 
 func (self *Game) DrawCarHiRes(_, hiResCanvas *ebiten.Image) {
-	xl, xr := self.vehicleCX - 3.0, self.vehicleCX + 3.0
-	yt, yb := self.vehicleCY - 4.0, self.vehicleCY + 4.0
+	xl, xr := self.vehicleCX - VehicleHalfWidth, self.vehicleCX + VehicleHalfWidth
+	yt, yb := self.vehicleCY - VehicleHalfHeight, self.vehicleCY + VehicleHalfHeight
 	mipix.HiRes().FillOverRect(hiResCanvas, xl, yt, xr, yb, VehicleRGB)
 }
 
@@ -198,18 +263,71 @@ func main() {
 	mipix.SetResolution(GameWidth, GameHeight)
 	ebiten.SetWindowResizingMode(ebiten.WindowResizingModeEnabled)
 
-	// configure off-road shaker
-	var offRoadShaker shaker.Spring
+	// parse flags. On the browser, the high scores name is used
+	// as the local storage key instead of a path, and the seed
+	// can also be given with a "seed" URL query parameter
+	scoresName := flag.String("scores", "driver_scores.txt", "high scores file")
+	seedFlag := flag.String("seed", "", "track seed (random for each run if not set)")
+	flag.Parse()
+
+	// create the track generator. Invalid seeds on the URL are
+	// ignored, as links are easy to mistype, but invalid flags
+	// are not
+	gen := NewTrackGenerator(0)
+	fixedSeed, message := false, ""
+	if *seedFlag != "" {
+		seed, err := ParseSeed(*seedFlag)
+		if err != nil { panic("invalid seed '" + *seedFlag + "'") }
+		gen.Reseed(seed)
+		fixedSeed = true
+	} else if seedParam := getQueryParam("seed"); seedParam != "" {
+		seed, err := ParseSeed(seedParam)
+		if err != nil {
+			message = "Invalid seed '" + seedParam + "', using random seeds"
+		} else {
+			gen.Reseed(seed)
+			fixedSeed = true
+		}
+	}
+
+	// configure shakers, seeded by the generator
+	offRoadShaker := NewSpringShaker(gen.Shakes())
 	offRoadShaker.SetMotionScale(0.01, 0.007)
 	offRoadShaker.SetParameters(0.1, 32.0)
 	offRoadShaker.SetZoomCompensation(0.5)
-	mipix.Camera().SetShaker(&offRoadShaker)
+	mipix.Camera().SetShaker(offRoadShaker, ChanOffRoad)
+	impactShaker := NewRandomShaker(gen.Shakes())
+	impactShaker.SetMotionScale(0.03)
+	mipix.Camera().SetShaker(impactShaker, ChanImpact)
+
+	// configure a zoomer and tracker that can be reset on restarts
+	zoomer, tracker := &SnapZoomer{}, NewSnapTracker()
+	mipix.Camera().SetZoomer(zoomer)
+	mipix.Camera().SetTracker(tracker)
+
+	// load high scores. Unavailable or broken storage only
+	// starts an empty table, the game can be played anyway
+	highScores, err := LoadHighScores(*scoresName)
+	if err != nil {
+		highScores = &HighScores{}
+		if message != "" { message += ". " }
+		message += "High score load failed: " + err.Error()
+	}
 
 	// create and run the game
-	game := Game{ ui: mipix.NewOffscreen(GameWidth, GameHeight) }
-	game.track[0].Reroll(0, GameHeight/5.0)
-	game.track[0].fx = 0
-	game.track[1].Reroll(0, game.track[0].fy)
-	err := mipix.Run(&game)
+	game := Game{
+		ui: mipix.NewOffscreen(GameWidth, GameHeight),
+		highScores: highScores,
+		highScoresName: *scoresName,
+		gen: gen,
+		fixedSeed: fixedSeed,
+		message: message,
+		offRoadShaker: offRoadShaker,
+		impactShaker: impactShaker,
+		zoomer: zoomer,
+		tracker: tracker,
+	}
+	game.Restart()
+	err = mipix.Run(&game)
 	if err != nil { panic(err) }
 }
@@ -0,0 +1,9 @@
+//go:build !js
+
+package main
+
+// Returns the value of the given query parameter on the page
+// URL. On desktop there's no URL, so it's always empty.
+func getQueryParam(name string) string {
+	return ""
+}
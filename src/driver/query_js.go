@@ -0,0 +1,17 @@
+//go:build js
+
+package main
+
+import "syscall/js"
+
+// Returns the value of the given query parameter on the page
+// URL, or an empty string if missing.
+func getQueryParam(name string) (value string) {
+	defer func() { // just in case, like for local storage
+		if recovered := recover(); recovered != nil { value = "" }
+	}()
+	search := js.Global().Get("location").Get("search")
+	param := js.Global().Get("URLSearchParams").New(search).Call("get", name)
+	if param.IsNull() || param.IsUndefined() { return "" }
+	return param.String()
+}
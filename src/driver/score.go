@@ -0,0 +1,172 @@
+package main
+
+import ("fmt" ; "io" ; "bufio" ; "bytes" ; "errors" ; "slices" ; "strconv" ; "strings" ; "io/fs")
+
+// Scoring parameters, in logical pixels and ticks.
+const (
+	ScoreDistanceUnit = 4.0 // distance travelled per point, before multipliers
+	ScoreStreakTicks = 60*4 // ticks on road at cruise speed needed for each multiplier step
+	ScoreCruiseDistance = 0.23 // distance per tick at cruise speed, going straight
+	ScoreMaxMultiplier = 5
+	ScorePenalty = 20 // points lost each time the vehicle goes off road or crashes
+	ScoreMaxOffRoadTicks = 60*2 // ticks off road in a row before the run ends
+	HighScoreCount = 5 // entries kept on the high score table
+)
+
+// --- score ---
+
+// The score for a single run. Points are earned for the distance
+// travelled on road, multiplied by the current streak, and lost
+// when going off road. Staying off road for too long ends the run.
+type Score struct {
+	points float64
+	distance float64
+	ticks, onRoadTicks int
+	streakTicks, bestStreakTicks float64 // consecutive ticks on road, scaled to cruise speed
+	offRoadTicks int // consecutive ticks off road
+	penalties int
+}
+
+// Updates the score with the distance travelled during the last
+// tick and whether the vehicle is on the road. Streaks grow with
+// the distance, so they don't while stopped. Returns false once
+// the run is over.
+func (self *Score) Update(distance float64, onRoad bool) bool {
+	if distance > 0 { self.ticks += 1 }
+	self.distance += distance
+	if onRoad {
+		self.points += self.Multiplier()*distance/ScoreDistanceUnit
+		self.offRoadTicks = 0
+		if distance <= 0 { return true }
+		self.onRoadTicks += 1
+		self.streakTicks += distance/ScoreCruiseDistance // no streaks while stopped
+		self.bestStreakTicks = max(self.bestStreakTicks, self.streakTicks)
+		return true
+	}
+	if self.offRoadTicks == 0 { self.Penalize() }
+	self.offRoadTicks += 1
+	return self.offRoadTicks < ScoreMaxOffRoadTicks
+}
+
+// Applies a penalty for crashing into something, which
+// also breaks the current streak.
+func (self *Score) Penalize() {
+	self.points = max(self.points - ScorePenalty, 0)
+	self.penalties += 1
+	self.streakTicks = 0
+}
+
+func (self *Score) Points() int {
+	return int(self.points)
+}
+
+func (self *Score) Multiplier() float64 {
+	return float64(min(1 + int(self.streakTicks/ScoreStreakTicks), ScoreMaxMultiplier))
+}
+
+// Returns how close the run is to ending due to being off road,
+// from 0 (on road) to 1.
+func (self *Score) OffRoadRatio() float64 {
+	return float64(self.offRoadTicks)/ScoreMaxOffRoadTicks
+}
+
+func (self *Score) Distance() float64 { return self.distance }
+func (self *Score) Penalties() int { return self.penalties }
+func (self *Score) BestStreakTicks() float64 { return self.bestStreakTicks }
+
+// Returns the fraction of the run spent on road while
+// moving, from 0 to 1.
+func (self *Score) OnRoadRatio() float64 {
+	if self.ticks == 0 { return 1.0 }
+	return float64(self.onRoadTicks)/float64(self.ticks)
+}
+
+// --- high scores ---
+
+type HighScore struct {
+	Points int
+	Distance int // in logical pixels
+}
+
+// The best scores, sorted from best to worst. High scores are
+// stored as plain text, with one "<points> <distance>" entry
+// per line and '#' comments.
+type HighScores struct {
+	entries []HighScore
+}
+
+// Loads the high scores from the storage entry with the given
+// name (see [readStorage]). Missing entries are not an error,
+// they simply lead to an empty table.
+func LoadHighScores(name string) (*HighScores, error) {
+	data, err := readStorage(name)
+	if errors.Is(err, fs.ErrNotExist) { return &HighScores{}, nil }
+	if err != nil { return nil, err }
+	return ParseHighScores(bytes.NewReader(data), name)
+}
+
+func ParseHighScores(reader io.Reader, name string) (*HighScores, error) {
+	var scores HighScores
+	scanner := bufio.NewScanner(reader)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum += 1
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i != -1 { line = line[ : i] }
+		fields := strings.Fields(line)
+		if len(fields) == 0 { continue }
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected '<points> <distance>', found %d fields", name, lineNum, len(fields))
+		}
+		points, err := strconv.Atoi(fields[0])
+		if err != nil || points < 0 { return nil, fmt.Errorf("%s:%d: invalid points '%s'", name, lineNum, fields[0]) }
+		distance, err := strconv.Atoi(fields[1])
+		if err != nil || distance < 0 { return nil, fmt.Errorf("%s:%d: invalid distance '%s'", name, lineNum, fields[1]) }
+		scores.insert(HighScore{ Points: points, Distance: distance })
+	}
+	err := scanner.Err()
+	if err != nil { return nil, err }
+	return &scores, nil
+}
+
+func (self *HighScores) Write(writer io.Writer) error {
+	_, err := fmt.Fprint(writer, "# <points> <distance>\n")
+	if err != nil { return err }
+	for _, entry := range self.entries {
+		_, err = fmt.Fprintf(writer, "%d %d\n", entry.Points, entry.Distance)
+		if err != nil { return err }
+	}
+	return nil
+}
+
+// Saves the high scores to the storage entry with the given name.
+func (self *HighScores) Save(name string) error {
+	var buffer bytes.Buffer
+	err := self.Write(&buffer)
+	if err != nil { return err }
+	return writeStorage(name, buffer.Bytes())
+}
+
+func (self *HighScores) Entries() []HighScore {
+	return self.entries
+}
+
+// Adds the score of a finished run to the table. Returns its
+// rank, starting from 0, or -1 if it didn't make it to the table.
+func (self *HighScores) Add(score *Score) int {
+	return self.insert(HighScore{ Points: score.Points(), Distance: int(score.Distance()) })
+}
+
+func (self *HighScores) insert(entry HighScore) int {
+	// ties are placed after existing entries
+	rank, _ := slices.BinarySearchFunc(self.entries, entry.Points, func(e HighScore, points int) int {
+		if e.Points >= points { return -1 }
+		return 1
+	})
+	if rank >= HighScoreCount { return -1 }
+	self.entries = slices.Insert(self.entries, rank, entry)
+	if len(self.entries) > HighScoreCount {
+		self.entries = self.entries[ : HighScoreCount]
+	}
+	return rank
+}
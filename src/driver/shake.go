@@ -0,0 +1,164 @@
+package main
+
+import ("math" ; "math/rand/v2")
+import "github.com/hajimehoshi/ebiten/v2"
+import "github.com/tinne26/mipix"
+import "github.com/tinne26/mipix/shaker"
+
+// The mipix shakers roll their targets with the global random
+// functions, which can't be seeded, so the driver uses these
+// shakers instead. They follow shaker.Spring and shaker.Random,
+// but roll from the given source, and only while shaking, so
+// termination calls don't affect the rolls of later shakes.
+
+var _ shaker.Shaker = (*SpringShaker)(nil)
+var _ shaker.Shaker = (*RandomShaker)(nil)
+
+// --- spring shaker ---
+
+// Moves towards random targets following a damped spring.
+type SpringShaker struct {
+	rng *rand.Rand
+	x, y, xSpeed, ySpeed float64
+	xTarget, yTarget float64
+	rolled bool // whether the current targets are valid
+	xScale, yScale float64
+	damping, power float64
+	zoomCompensation float64
+}
+
+func NewSpringShaker(rng *rand.Rand) *SpringShaker {
+	return &SpringShaker{ rng: rng, xScale: 0.02, yScale: 0.02, damping: 0.25, power: 80.0 }
+}
+
+// See shaker.Spring.SetMotionScale().
+func (self *SpringShaker) SetMotionScale(xScalingFactor, yScalingFactor float64) {
+	self.xScale, self.yScale = xScalingFactor, yScalingFactor
+}
+
+// See shaker.Spring.SetParameters(). Damping must be below 1.
+func (self *SpringShaker) SetParameters(damping, power float64) {
+	self.damping, self.power = damping, power
+}
+
+// See shaker.Spring.SetZoomCompensation().
+func (self *SpringShaker) SetZoomCompensation(compensation float64) {
+	self.zoomCompensation = compensation
+}
+
+// Drops the current motion, like after a termination call.
+func (self *SpringShaker) Reset() {
+	self.x, self.y, self.xSpeed, self.ySpeed = 0, 0, 0, 0
+	self.rolled = false
+}
+
+// Implements the [shaker.Shaker] interface.
+func (self *SpringShaker) GetShakeOffsets(level float64) (float64, float64) {
+	if level == 0.0 {
+		self.Reset()
+		return 0.0, 0.0
+	}
+	if !self.rolled { self.rollTarget() }
+
+	self.x, self.xSpeed = self.updateSpring(self.x, self.xTarget, self.xSpeed)
+	self.y, self.ySpeed = self.updateSpring(self.y, self.yTarget, self.ySpeed)
+	if math.Abs(self.xTarget - self.x) < 0.08 && math.Abs(self.yTarget - self.y) < 0.08 {
+		self.rollTarget()
+	}
+
+	w, h := mipix.GetResolution()
+	xOffset, yOffset := self.x*float64(w)*self.xScale, self.y*float64(h)*self.yScale
+	if self.zoomCompensation != 0.0 {
+		zoom, _ := mipix.Camera().GetZoom()
+		compensatedZoom := 1.0 + (zoom - 1.0)*self.zoomCompensation
+		xOffset /= compensatedZoom
+		yOffset /= compensatedZoom
+	}
+	return xOffset*level, yOffset*level
+}
+
+func (self *SpringShaker) rollTarget() {
+	self.xTarget, self.yTarget = self.rng.Float64() - 0.5, self.rng.Float64() - 0.5
+	self.rolled = true
+}
+
+// Advances an underdamped spring by one tick, solving it
+// analytically. Returns the new position and speed.
+func (self *SpringShaker) updateSpring(current, target, speed float64) (float64, float64) {
+	delta := 1.0/float64(ebiten.TPS())
+	freqByDamp := self.power*self.damping
+	alpha := self.power*math.Sqrt(1.0 - self.damping*self.damping)
+	exp := math.Exp(-freqByDamp*delta)
+	sinExp, cosExp := math.Sin(alpha*delta)*exp, math.Cos(alpha*delta)*exp
+	expr := sinExp*freqByDamp/alpha
+	posPos, posVel := cosExp + expr, sinExp/alpha
+	velPos, velVel := -sinExp*alpha - freqByDamp*expr, cosExp - expr
+
+	mirroredStart := current - target
+	current = mirroredStart*posPos + speed*posVel + target
+	speed   = mirroredStart*velPos + speed*velVel
+	return current, speed
+}
+
+// --- random shaker ---
+
+// Moves between random points with quadratic easing.
+type RandomShaker struct {
+	rng *rand.Rand
+	fromX, fromY, toX, toY float64
+	rolled bool // whether the current targets are valid
+	elapsed float64
+	travelTime float64 // in seconds
+	scale float64
+}
+
+func NewRandomShaker(rng *rand.Rand) *RandomShaker {
+	return &RandomShaker{ rng: rng, travelTime: 0.03, scale: 0.02 }
+}
+
+// See shaker.Random.SetMotionScale().
+func (self *RandomShaker) SetMotionScale(axisScalingFactor float64) {
+	self.scale = axisScalingFactor
+}
+
+// Drops the current motion, like after a termination call.
+func (self *RandomShaker) Reset() {
+	self.fromX, self.fromY, self.toX, self.toY = 0, 0, 0, 0
+	self.elapsed = 0
+	self.rolled = false
+}
+
+// Implements the [shaker.Shaker] interface.
+func (self *RandomShaker) GetShakeOffsets(level float64) (float64, float64) {
+	if level == 0.0 {
+		self.Reset()
+		return 0.0, 0.0
+	}
+	if !self.rolled { self.rollTarget() }
+
+	t := self.elapsed/self.travelTime
+	x, y := lerp(self.fromX, self.toX, quadInOut(t)), lerp(self.fromY, self.toY, quadInOut(t))
+	self.elapsed += 1.0/float64(ebiten.TPS())
+	for self.elapsed >= self.travelTime {
+		self.elapsed -= self.travelTime
+		self.rollTarget()
+	}
+
+	w, h := mipix.GetResolution()
+	axisRange := float64(min(w, h))*self.scale
+	level = level*level*(3.0 - 2.0*level) // smoothstep
+	return x*axisRange*level, y*axisRange*level
+}
+
+func (self *RandomShaker) rollTarget() {
+	self.fromX, self.fromY = self.toX, self.toY
+	self.toX, self.toY = self.rng.Float64() - 0.5, self.rng.Float64() - 0.5
+	self.rolled = true
+}
+
+func quadInOut(t float64) float64 {
+	t = min(max(t, 0.0), 1.0)
+	if t < 0.5 { return 2.0*t*t }
+	t = 2.0*t - 1.0
+	return -0.5*(t*(t - 2.0) - 1.0)
+}
@@ -0,0 +1,18 @@
+//go:build !js
+
+package main
+
+import "os"
+
+// Reads the storage entry with the given name. On desktop,
+// entries are files, and names are their paths. Missing
+// entries return an error matching [fs.ErrNotExist].
+func readStorage(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+// Writes the storage entry with the given name, replacing
+// any previous data.
+func writeStorage(name string, data []byte) error {
+	return os.WriteFile(name, data, 0644)
+}
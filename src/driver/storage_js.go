@@ -0,0 +1,45 @@
+//go:build js
+
+package main
+
+import ("errors" ; "io/fs" ; "syscall/js")
+
+// Reads the storage entry with the given name. On the browser,
+// entries are kept on the local storage, with names as keys.
+// Missing entries return an error matching [fs.ErrNotExist].
+func readStorage(name string) ([]byte, error) {
+	storage, err := getLocalStorage()
+	if err != nil { return nil, err }
+	value := storage.Call("getItem", name)
+	if value.IsNull() { return nil, fs.ErrNotExist }
+	return []byte(value.String()), nil
+}
+
+// Writes the storage entry with the given name, replacing
+// any previous data.
+func writeStorage(name string, data []byte) (err error) {
+	storage, err := getLocalStorage()
+	if err != nil { return err }
+	defer func() { // setItem throws if the storage is full or disabled
+		if recovered := recover(); recovered != nil {
+			err = errors.New("local storage write failed")
+		}
+	}()
+	storage.Call("setItem", name, string(data))
+	return nil
+}
+
+// Returns the window local storage. Some browsers throw when
+// accessing it with storage disabled, so that's caught too.
+func getLocalStorage() (storage js.Value, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = errors.New("local storage not available")
+		}
+	}()
+	storage = js.Global().Get("localStorage")
+	if storage.IsUndefined() || storage.IsNull() {
+		return js.Value{}, errors.New("local storage not available")
+	}
+	return storage, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import ("math" ; "math/rand/v2")
+import "github.com/hajimehoshi/ebiten/v2"
+import "github.com/tinne26/mipix"
+import "github.com/tinne26/mipix/utils"
+
+var RockRGB, OilRGB = utils.RGB(112, 104, 98), utils.RGBA(20, 16, 30, 200)
+var RivalRGB = utils.RGB(255, 196, 40)
+
+// Traffic parameters, in pixels and ticks. Sizes are half
+// widths and heights, like for the player vehicle.
+const (
+	RockHalfSize = 1.5
+	OilHalfWidth, OilHalfHeight = 3.0, 2.0
+	RivalHalfWidth, RivalHalfHeight = 3.0, 4.0
+	RivalMinSpeed, RivalMaxSpeed = 0.10, 0.19 // pixels up per tick
+	RivalBumpTicks = 60 // ticks after a collision where the rival can't collide again
+	RivalSwerveSpeed = 0.12 // max lane change per tick, in pixels
+	VehicleHalfWidth, VehicleHalfHeight = 3.0, 4.0
+)
+
+// --- traffic ---
+
+type ObstacleKind uint8
+const (
+	ObstacleRock ObstacleKind = iota // slows the vehicle down on impact
+	ObstacleOil // makes the vehicle swerve
+)
+
+type Obstacle struct {
+	Kind ObstacleKind
+	X, Y float64 // center
+}
+
+func (self *Obstacle) halfSize() (float64, float64) {
+	if self.Kind == ObstacleRock { return RockHalfSize, RockHalfSize }
+	return OilHalfWidth, OilHalfHeight
+}
+
+// AI-controlled vehicle, driving up the track at a constant
+// speed and keeping the same offset from the road center, or
+// gradually moving towards a new one after a collision.
+type Rival struct {
+	X, Y float64 // center
+	Lane float64 // horizontal offset from the road center
+	TargetLane float64
+	Speed float64
+	bumpTicks int
+}
+
+type Impact uint8
+const (
+	ImpactNone Impact = iota
+	ImpactRock
+	ImpactOil
+	ImpactRival
+)
+
+// Obstacles and rival vehicles on the track. They are spawned
+// along each curve when it's rerolled, and removed once they
+// are left behind.
+type Traffic struct {
+	obstacles []Obstacle
+	rivals []Rival
+}
+
+func (self *Traffic) Clear() {
+	self.obstacles = self.obstacles[ : 0]
+	self.rivals = self.rivals[ : 0]
+}
+
+// Spawns a few obstacles and maybe a rival along the curve,
+// using the given random source (see [TrackGenerator]).
+func (self *Traffic) Spawn(rng *rand.Rand, curve *Curve) {
+	for range rng.IntN(3) {
+		x, y := randomRoadPoint(rng, curve, RockHalfSize)
+		self.obstacles = append(self.obstacles, Obstacle{ Kind: ObstacleRock, X: x, Y: y })
+	}
+	if rng.Float64() < 0.5 {
+		x, y := randomRoadPoint(rng, curve, OilHalfWidth)
+		self.obstacles = append(self.obstacles, Obstacle{ Kind: ObstacleOil, X: x, Y: y })
+	}
+	if rng.Float64() < 0.6 {
+		x, y := randomRoadPoint(rng, curve, RivalHalfWidth)
+		speed := RivalMinSpeed + (RivalMaxSpeed - RivalMinSpeed)*rng.Float64()
+		lane := x - curve.GetClosestX(y)
+		self.rivals = append(self.rivals, Rival{ X: x, Y: y, Lane: lane, TargetLane: lane, Speed: speed })
+	}
+}
+
+// Returns a random point on the road along the curve, keeping
+// the given horizontal margin from the road edges.
+func randomRoadPoint(rng *rand.Rand, curve *Curve, margin float64) (float64, float64) {
+	y := curve.fy + (curve.oy - curve.fy)*rng.Float64()
+	offset := (rng.Float64()*2.0 - 1.0)*(RoadWidth/2.0 - margin)
+	return curve.GetClosestX(y) + offset, y
+}
+
+// Moves the rivals along the track, and removes everything
+// below the given y. Rivals running past the end of the track
+// are removed too.
+func (self *Traffic) Update(track *[2]Curve, cutoffY float64) {
+	trackEndY := min(track[0].fy, track[1].fy)
+	rivals := self.rivals[ : 0]
+	for _, rival := range self.rivals {
+		rival.Y -= rival.Speed
+		laneDelta := min(max(rival.TargetLane - rival.Lane, -RivalSwerveSpeed), RivalSwerveSpeed)
+		rival.Lane += laneDelta
+		rival.X = getTrackX(track, rival.Y) + rival.Lane
+		if rival.bumpTicks > 0 { rival.bumpTicks -= 1 }
+		if rival.Y - RivalHalfHeight > cutoffY || rival.Y < trackEndY { continue }
+		rivals = append(rivals, rival)
+	}
+	self.rivals = rivals
+
+	obstacles := self.obstacles[ : 0]
+	for _, obstacle := range self.obstacles {
+		if obstacle.Y - OilHalfHeight > cutoffY { continue }
+		obstacles = append(obstacles, obstacle)
+	}
+	self.obstacles = obstacles
+}
+
+// Returns the road center x at the given y, from the curve
+// containing it. Beyond the track, the x of the closest end
+// point is returned instead.
+func getTrackX(track *[2]Curve, y float64) float64 {
+	top, bottom := &track[0], &track[1]
+	if bottom.fy < top.fy { top, bottom = bottom, top }
+	if bottom.ContainsY(y) { return bottom.GetClosestX(y) }
+	return top.GetClosestX(y)
+}
+
+// Checks whether the vehicle centered at the given coordinates
+// collides with any obstacle or rival. Rocks and oil slicks are
+// removed on impact, and rivals can't collide again for a while,
+// swerving towards the road edge away from the vehicle.
+// Only the first impact is reported.
+func (self *Traffic) Collide(x, y float64) Impact {
+	for i, obstacle := range self.obstacles {
+		hw, hh := obstacle.halfSize()
+		if !vehicleOverlaps(x, y, obstacle.X, obstacle.Y, hw, hh) { continue }
+		self.obstacles = append(self.obstacles[ : i], self.obstacles[i + 1 : ]...)
+		if obstacle.Kind == ObstacleRock { return ImpactRock }
+		return ImpactOil
+	}
+	for i := range self.rivals {
+		rival := &self.rivals[i]
+		if rival.bumpTicks > 0 { continue }
+		if !vehicleOverlaps(x, y, rival.X, rival.Y, RivalHalfWidth, RivalHalfHeight) { continue }
+		rival.bumpTicks = RivalBumpTicks
+		maxLane := RoadWidth/2.0 - RivalHalfWidth
+		if rival.X < x { maxLane = -maxLane } // swerve away from the vehicle
+		rival.TargetLane = maxLane
+		return ImpactRival
+	}
+	return ImpactNone
+}
+
+func vehicleOverlaps(x, y, ox, oy, halfWidth, halfHeight float64) bool {
+	return math.Abs(x - ox) < VehicleHalfWidth + halfWidth && math.Abs(y - oy) < VehicleHalfHeight + halfHeight
+}
+
+// Draws obstacles and rivals at their exact sub-pixel positions.
+func (self *Traffic) DrawHiRes(_, hiResCanvas *ebiten.Image) {
+	for _, obstacle := range self.obstacles {
+		hw, hh := obstacle.halfSize()
+		clr := RockRGB
+		if obstacle.Kind == ObstacleOil { clr = OilRGB }
+		mipix.HiRes().FillOverRect(hiResCanvas, obstacle.X - hw, obstacle.Y - hh, obstacle.X + hw, obstacle.Y + hh, clr)
+	}
+	for _, rival := range self.rivals {
+		xl, xr := rival.X - RivalHalfWidth, rival.X + RivalHalfWidth
+		yt, yb := rival.Y - RivalHalfHeight, rival.Y + RivalHalfHeight
+		mipix.HiRes().FillOverRect(hiResCanvas, xl, yt, xr, yb, RivalRGB)
+	}
+}
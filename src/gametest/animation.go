@@ -0,0 +1,183 @@
+package main
+
+import "fmt"
+
+import "github.com/tinne26/mipix"
+import "github.com/hajimehoshi/ebiten/v2"
+
+// --- animation ---
+
+type PlaybackMode uint8
+const (
+	PlaybackLoop     PlaybackMode = iota // plays forward, then jumps back to the loop index
+	PlaybackOnce     // plays forward once and stops at the last frame, see Animation.Done()
+	PlaybackPingPong // plays forward, then back and forth between the loop index and the last frame
+	PlaybackReverse  // like PlaybackLoop, but starting from the last frame and going backwards
+)
+
+// An animation is a sequence of frames with individual durations.
+//
+// The loop index and the pre-loop phase always refer to playback
+// positions, not frame indices, so in reverse mode a loop index of
+// 1 means that the last frame is only played once after a restart.
+type Animation struct {
+	frames []*ebiten.Image
+	frameDurations []mipix.TicksDuration
+	frameDurationLeft mipix.TicksDuration
+	position int // playback position, see GetFrameIndex()
+	step int // +1 or -1, only goes backwards on ping-pong mode
+	loopIndex int
+	mode PlaybackMode
+	done bool
+}
+
+// Appends a frame to the animation and restarts it.
+func (self *Animation) AddFrame(frame *ebiten.Image, durationTicks mipix.TicksDuration) {
+	if durationTicks == 0 { panic("durationTicks == 0") }
+	self.frames = append(self.frames, frame)
+	self.frameDurations = append(self.frameDurations, durationTicks)
+	self.Restart()
+}
+
+// Sets the playback mode and restarts the animation.
+func (self *Animation) SetPlaybackMode(mode PlaybackMode) {
+	if mode > PlaybackReverse { panic("invalid playback mode") }
+	self.mode = mode
+	self.Restart()
+}
+
+func (self *Animation) GetPlaybackMode() PlaybackMode {
+	return self.mode
+}
+
+// Advances the animation by one tick. Returns true if a new
+// frame was entered, even if it's the same frame as before
+// due to looping.
+func (self *Animation) Update() bool {
+	if self.done { return false }
+	self.frameDurationLeft -= 1
+	if self.frameDurationLeft > 0 { return false }
+
+	last := len(self.frames) - 1
+	switch self.mode {
+	case PlaybackLoop, PlaybackReverse:
+		if self.position == last {
+			self.position = self.loopIndex
+		} else {
+			self.position += 1
+		}
+	case PlaybackOnce:
+		if self.position == last {
+			self.done = true
+			return false
+		}
+		self.position += 1
+	case PlaybackPingPong:
+		next := self.position + self.step
+		if next > last || (self.step < 0 && next < self.loopIndex) {
+			self.step = -self.step
+			next = self.position + self.step
+			if next > last || next < self.loopIndex { next = self.position }
+		}
+		self.position = next
+	default:
+		panic("invalid playback mode")
+	}
+	self.frameDurationLeft = self.frameDurations[self.GetFrameIndex()]
+	return true
+}
+
+// Sets the playback position to jump back to after the last
+// one. Positions before the loop index are only played once,
+// after a restart. Ignored on [PlaybackOnce] mode.
+func (self *Animation) SetLoopIndex(index int) {
+	if index < 0 || index >= len(self.frames) { panic("loop index out of range") }
+	self.loopIndex = index
+}
+
+func (self *Animation) GetFrame() *ebiten.Image {
+	return self.frames[self.GetFrameIndex()]
+}
+
+func (self *Animation) GetFrameIndex() int {
+	if self.mode == PlaybackReverse {
+		return len(self.frames) - 1 - self.position
+	}
+	return self.position
+}
+
+// Returns the total duration of all the animation
+// frames, in ticks, ignoring looping.
+func (self *Animation) Duration() int {
+	var ticks int
+	for _, duration := range self.frameDurations {
+		ticks += int(duration)
+	}
+	return ticks
+}
+
+// Returns whether the animation is still on the part that is
+// only played once after a restart. On [PlaybackOnce] mode,
+// this is the case until the animation is done.
+func (self *Animation) InPreLoopPhase() bool {
+	if self.mode == PlaybackOnce { return !self.done }
+	return self.position < self.loopIndex
+}
+
+// Returns whether a [PlaybackOnce] animation has finished
+// showing its last frame. Always false on other modes.
+func (self *Animation) Done() bool {
+	return self.done
+}
+
+func (self *Animation) Restart() {
+	self.position, self.step, self.done = 0, 1, false
+	if len(self.frames) == 0 { return }
+	self.frameDurationLeft = self.frameDurations[self.GetFrameIndex()]
+}
+
+// Playback state of an animation, used to save and restore it.
+// Frames, durations and modes are not included, so snapshots can
+// only be restored on the same animation they were taken from.
+type AnimationSnapshot struct {
+	Position int `json:"position"`
+	Step int `json:"step"`
+	FrameTicksLeft mipix.TicksDuration `json:"frame_ticks_left"`
+	Done bool `json:"done"`
+}
+
+func (self *Animation) Snapshot() AnimationSnapshot {
+	return AnimationSnapshot{
+		Position: self.position,
+		Step: self.step,
+		FrameTicksLeft: self.frameDurationLeft,
+		Done: self.done,
+	}
+}
+
+// Restores the playback state from a snapshot. On failure, the
+// animation is left unchanged.
+func (self *Animation) Restore(snapshot AnimationSnapshot) error {
+	if snapshot.Position < 0 || snapshot.Position >= len(self.frames) {
+		return fmt.Errorf("animation position %d out of range", snapshot.Position)
+	}
+	if snapshot.Step != 1 && snapshot.Step != -1 {
+		return fmt.Errorf("invalid animation step %d", snapshot.Step)
+	}
+	self.position, self.step, self.done = snapshot.Position, snapshot.Step, snapshot.Done
+	maxTicks := self.frameDurations[self.GetFrameIndex()]
+	self.frameDurationLeft = min(max(snapshot.FrameTicksLeft, 1), maxTicks)
+	return nil
+}
+
+var IdleAnimation *Animation
+var MoveAnimation *Animation
+var AirAnimation *Animation
+var DeathAnimation *Animation
+var LandAnimation *Animation
+
+func mustGetAnimation(animations map[string]*Animation, name string) *Animation {
+	animation, found := animations[name]
+	if !found { panic("missing animation '" + name + "'") }
+	return animation
+}
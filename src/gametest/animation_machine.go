@@ -0,0 +1,247 @@
+package main
+
+import "fmt"
+
+import "github.com/hajimehoshi/ebiten/v2"
+
+// A transition between two states of an [AnimationMachine].
+type AnimationTransition struct {
+	From string // source state name, or "*" for any state
+	To string
+	Condition func() bool
+
+	// Minimum number of ticks the source state must have been
+	// active before the transition can happen. Zero by default.
+	HoldTicks int
+
+	// Optional animation played once between the two states,
+	// typically a few frames to smooth out the change. Frame
+	// events are not emitted for blend animations.
+	Blend *Animation
+}
+
+type animationState struct {
+	animation *Animation
+	transitions []AnimationTransition
+	events map[int][]string // frame index to event names
+}
+
+// An animation state machine built on top of [Animation].
+// Each named state has an animation, and transitions between
+// states happen automatically when their conditions are met.
+// Frame events can be attached to specific frames of each
+// state and are delivered through the event handler when
+// the frame is entered during [AnimationMachine.Update]().
+type AnimationMachine struct {
+	states map[string]*animationState
+	anyTransitions []AnimationTransition // transitions from "*"
+	current string
+	currentTicks int // ticks since entering the current state
+	blend *Animation // non-nil while a blend animation is playing
+	blendFrom string // source state of the blend transition
+	blendTicksLeft int
+	eventHandler func(event string)
+}
+
+func NewAnimationMachine() *AnimationMachine {
+	return &AnimationMachine{ states: make(map[string]*animationState, 8) }
+}
+
+// Adds a new state. The first state added becomes the current one.
+func (self *AnimationMachine) AddState(name string, animation *Animation) {
+	if name == "*" { panic("'*' is not a valid state name") }
+	if _, found := self.states[name]; found { panic("duplicate state '" + name + "'") }
+	self.states[name] = &animationState{ animation: animation }
+	if self.current == "" { self.SetState(name) }
+}
+
+// Adds a transition. Transitions are evaluated in the order
+// they were added, with transitions from "*" taking priority,
+// and only the first one whose condition is met is applied.
+// Blend animations can only be interrupted by "*" transitions.
+func (self *AnimationMachine) AddTransition(transition AnimationTransition) {
+	if transition.Condition == nil { panic("nil transition condition") }
+	self.mustGetState(transition.To)
+	if transition.From == "*" {
+		self.anyTransitions = append(self.anyTransitions, transition)
+	} else {
+		state := self.mustGetState(transition.From)
+		state.transitions = append(state.transitions, transition)
+	}
+}
+
+// Attaches a named event to a frame of the given state.
+func (self *AnimationMachine) AddEvent(stateName string, frameIndex int, event string) {
+	state := self.mustGetState(stateName)
+	if frameIndex < 0 || frameIndex >= len(state.animation.frames) {
+		panic("frame index out of range")
+	}
+	if state.events == nil { state.events = make(map[int][]string, 2) }
+	state.events[frameIndex] = append(state.events[frameIndex], event)
+}
+
+// Sets the function that will receive frame events.
+func (self *AnimationMachine) SetEventHandler(handler func(event string)) {
+	self.eventHandler = handler
+}
+
+// Immediately switches to the given state, restarting its
+// animation even if it was already the current state. Any
+// blend animation in progress is discarded.
+func (self *AnimationMachine) SetState(name string) {
+	state := self.mustGetState(name)
+	self.current, self.currentTicks = name, 0
+	self.blend, self.blendFrom, self.blendTicksLeft = nil, "", 0
+	state.animation.Restart()
+	self.emitEvents(state)
+}
+
+func (self *AnimationMachine) GetState() string {
+	return self.current
+}
+
+// Returns the animation of the current state. Notice that
+// this might not be the animation being displayed if a
+// blend animation is in progress.
+func (self *AnimationMachine) GetAnimation() *Animation {
+	return self.states[self.current].animation
+}
+
+func (self *AnimationMachine) GetFrame() *ebiten.Image {
+	if self.blend != nil { return self.blend.GetFrame() }
+	return self.GetAnimation().GetFrame()
+}
+
+// Evaluates transitions and advances the current animation
+// by one tick, emitting any frame events on the way.
+func (self *AnimationMachine) Update() {
+	self.currentTicks += 1
+	if self.applyTransition(self.anyTransitions) { return }
+	if self.blend != nil {
+		self.blend.Update()
+		self.blendTicksLeft -= 1
+		if self.blendTicksLeft > 0 { return }
+		self.blend, self.blendFrom = nil, ""
+		state := self.states[self.current]
+		state.animation.Restart()
+		self.emitEvents(state)
+		return
+	}
+
+	state := self.states[self.current]
+	if self.applyTransition(state.transitions) { return }
+	if state.animation.Update() {
+		self.emitEvents(state)
+	}
+}
+
+// State of an [AnimationMachine], used to save and restore it.
+// Blend animations are identified by the source state of their
+// transition, which is "*" for transitions from any state.
+type AnimationMachineSnapshot struct {
+	State string `json:"state"`
+	StateTicks int `json:"state_ticks"`
+	Animation AnimationSnapshot `json:"animation"`
+	BlendFrom string `json:"blend_from,omitempty"` // empty if no blend is playing
+	BlendTicksLeft int `json:"blend_ticks_left,omitempty"`
+	Blend *AnimationSnapshot `json:"blend,omitempty"`
+}
+
+func (self *AnimationMachine) Snapshot() AnimationMachineSnapshot {
+	snapshot := AnimationMachineSnapshot{
+		State: self.current,
+		StateTicks: self.currentTicks,
+		Animation: self.GetAnimation().Snapshot(),
+	}
+	if self.blend != nil {
+		snapshot.BlendFrom = self.blendFrom
+		snapshot.BlendTicksLeft = self.blendTicksLeft
+		blend := self.blend.Snapshot()
+		snapshot.Blend = &blend
+	}
+	return snapshot
+}
+
+// Restores the machine state from a snapshot, without emitting
+// frame events. On failure, the machine is left unchanged.
+func (self *AnimationMachine) Restore(snapshot AnimationMachineSnapshot) error {
+	state, found := self.states[snapshot.State]
+	if !found { return fmt.Errorf("unknown animation state '%s'", snapshot.State) }
+	var blend *Animation
+	if snapshot.BlendFrom != "" {
+		blend = self.findBlend(snapshot.BlendFrom, snapshot.State)
+		if blend == nil {
+			return fmt.Errorf("no blend transition from '%s' to '%s'", snapshot.BlendFrom, snapshot.State)
+		}
+		if snapshot.Blend == nil || snapshot.BlendTicksLeft <= 0 {
+			return fmt.Errorf("incomplete blend state")
+		}
+	}
+
+	// restore animations on copies first, so failures don't leave
+	// the machine half restored
+	animation := *state.animation
+	err := animation.Restore(snapshot.Animation)
+	if err != nil { return err }
+	var blendAnimation Animation
+	if blend != nil {
+		blendAnimation = *blend
+		err = blendAnimation.Restore(*snapshot.Blend)
+		if err != nil { return err }
+	}
+
+	*state.animation = animation
+	self.current, self.currentTicks = snapshot.State, snapshot.StateTicks
+	self.blend, self.blendFrom, self.blendTicksLeft = nil, "", 0
+	if blend != nil {
+		*blend = blendAnimation
+		self.blend, self.blendFrom, self.blendTicksLeft = blend, snapshot.BlendFrom, snapshot.BlendTicksLeft
+	}
+	return nil
+}
+
+// Returns the blend animation of the transition between the
+// given states, or nil if there's none.
+func (self *AnimationMachine) findBlend(from, to string) *Animation {
+	transitions := self.anyTransitions
+	if from != "*" {
+		state, found := self.states[from]
+		if !found { return nil }
+		transitions = state.transitions
+	}
+	for _, transition := range transitions {
+		if transition.To == to && transition.Blend != nil { return transition.Blend }
+	}
+	return nil
+}
+
+func (self *AnimationMachine) applyTransition(transitions []AnimationTransition) bool {
+	for _, transition := range transitions {
+		if transition.To == self.current { continue }
+		if self.currentTicks < transition.HoldTicks { continue }
+		if !transition.Condition() { continue }
+		if transition.Blend == nil {
+			self.SetState(transition.To)
+		} else {
+			self.current, self.currentTicks = transition.To, 0
+			self.blend, self.blendFrom = transition.Blend, transition.From
+			self.blend.Restart()
+			self.blendTicksLeft = self.blend.Duration()
+		}
+		return true
+	}
+	return false
+}
+
+func (self *AnimationMachine) emitEvents(state *animationState) {
+	if self.eventHandler == nil || state.events == nil { return }
+	for _, event := range state.events[state.animation.GetFrameIndex()] {
+		self.eventHandler(event)
+	}
+}
+
+func (self *AnimationMachine) mustGetState(name string) *animationState {
+	state, found := self.states[name]
+	if !found { panic("unknown state '" + name + "'") }
+	return state
+}
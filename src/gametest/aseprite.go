@@ -0,0 +1,156 @@
+package main
+
+import "fmt"
+import "math"
+import "path"
+import "bytes"
+import "image"
+import "strconv"
+import "strings"
+import "io/fs"
+import "encoding/json"
+
+import "github.com/tinne26/mipix"
+import "github.com/hajimehoshi/ebiten/v2"
+
+// Partial structure of the JSON files exported by Aseprite's
+// "Export Sprite Sheet" option. Only the fields relevant to
+// building animations are declared.
+type asepriteSheet struct {
+	Frames json.RawMessage `json:"frames"` // array or hash
+	Meta struct {
+		Image string `json:"image"`
+		FrameTags []asepriteTag `json:"frameTags"`
+	} `json:"meta"`
+}
+
+type asepriteFrame struct {
+	Frame struct { X, Y, W, H int } `json:"frame"`
+	Duration int `json:"duration"` // milliseconds
+}
+
+type asepriteTag struct {
+	Name string `json:"name"`
+	From int `json:"from"`
+	To int `json:"to"`
+	Direction string `json:"direction"`
+	Repeat string `json:"repeat"` // empty for infinite
+	Data string `json:"data"` // tag user data
+}
+
+// Loads the animations from an Aseprite JSON sprite sheet export,
+// keyed by tag name. Both the "array" and "hash" frame formats are
+// supported. The sheet image is loaded from the path declared in
+// the meta section, relative to the JSON file.
+//
+// Frame durations are converted from milliseconds to ticks with the
+// given ticks per second. Tag directions map to playback modes, and
+// tags with a repeat count of 1 are played only once, on any direction:
+// reverse tags get their frames added backwards, and ping-pong tags
+// play only their forward pass, like in Aseprite. Loop points can
+// be declared through the tag user data as "loop=N", where N is the
+// playback position within the tag that playback jumps back to after
+// the last frame.
+func LoadAsepriteAnimations(filesys fs.FS, jsonPath string, ticksPerSecond int) (map[string]*Animation, error) {
+	data, err := fs.ReadFile(filesys, jsonPath)
+	if err != nil { return nil, err }
+	var sheet asepriteSheet
+	err = json.Unmarshal(data, &sheet)
+	if err != nil { return nil, fmt.Errorf("%s: %w", jsonPath, err) }
+	frames, err := parseAsepriteFrames(sheet.Frames)
+	if err != nil { return nil, fmt.Errorf("%s: %w", jsonPath, err) }
+	spritesheet, err := loadImage(filesys, path.Join(path.Dir(jsonPath), sheet.Meta.Image))
+	if err != nil { return nil, fmt.Errorf("%s: %w", jsonPath, err) }
+
+	animations := make(map[string]*Animation, len(sheet.Meta.FrameTags))
+	for _, tag := range sheet.Meta.FrameTags {
+		animation, err := newAsepriteAnimation(spritesheet, frames, tag, ticksPerSecond)
+		if err != nil { return nil, fmt.Errorf("%s: tag '%s': %w", jsonPath, tag.Name, err) }
+		animations[tag.Name] = animation
+	}
+	return animations, nil
+}
+
+func newAsepriteAnimation(spritesheet *ebiten.Image, frames []asepriteFrame, tag asepriteTag, ticksPerSecond int) (*Animation, error) {
+	if tag.From < 0 || tag.To >= len(frames) || tag.From > tag.To {
+		return nil, fmt.Errorf("invalid frame range [%d, %d]", tag.From, tag.To)
+	}
+	var mode PlaybackMode
+	switch tag.Direction {
+	case "", "forward" : mode = PlaybackLoop
+	case "reverse"     : mode = PlaybackReverse
+	case "pingpong"    : mode = PlaybackPingPong
+	default:
+		return nil, fmt.Errorf("unsupported direction '%s'", tag.Direction)
+	}
+	backwards := false // frames added from last to first
+	switch tag.Repeat {
+	case "", "0": // infinite
+	case "1":
+		backwards = (mode == PlaybackReverse)
+		mode = PlaybackOnce
+	default:
+		return nil, fmt.Errorf("unsupported repeat count '%s'", tag.Repeat)
+	}
+
+	var animation Animation
+	for n := range tag.To - tag.From + 1 {
+		i := tag.From + n
+		if backwards { i = tag.To - n }
+		frame := frames[i]
+		ticks := int64(math.Round(float64(frame.Duration)*float64(ticksPerSecond)/1000.0))
+		if ticks < 1 || ticks > math.MaxUint32 {
+			return nil, fmt.Errorf("frame %d lasts %d ticks, expected [1, %d]", i, ticks, uint32(math.MaxUint32))
+		}
+		rect := image.Rect(frame.Frame.X, frame.Frame.Y, frame.Frame.X + frame.Frame.W, frame.Frame.Y + frame.Frame.H)
+		animation.AddFrame(spritesheet.SubImage(rect).(*ebiten.Image), mipix.TicksDuration(ticks))
+	}
+
+	loopIndex, err := parseAsepriteLoopIndex(tag.Data)
+	if err != nil { return nil, err }
+	if loopIndex > tag.To - tag.From {
+		return nil, fmt.Errorf("loop index %d out of range", loopIndex)
+	}
+	animation.SetLoopIndex(loopIndex)
+	animation.SetPlaybackMode(mode)
+	return &animation, nil
+}
+
+// Parses the "loop=N" property from tag user data. Properties
+// are separated by commas or whitespace, unknown ones are ignored.
+func parseAsepriteLoopIndex(data string) (int, error) {
+	for _, field := range strings.Fields(strings.ReplaceAll(data, ",", " ")) {
+		value, found := strings.CutPrefix(field, "loop=")
+		if !found { continue }
+		index, err := strconv.Atoi(value)
+		if err != nil || index < 0 { return 0, fmt.Errorf("invalid loop index '%s'", value) }
+		return index, nil
+	}
+	return 0, nil
+}
+
+// Aseprite can export frames either as an array or as an object
+// keyed by frame filename. Go maps don't preserve the order of
+// the keys, so the object is decoded token by token instead.
+func parseAsepriteFrames(data json.RawMessage) ([]asepriteFrame, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 { return nil, fmt.Errorf("missing frames") }
+	var frames []asepriteFrame
+	if data[0] == '[' {
+		err := json.Unmarshal(data, &frames)
+		return frames, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	_, err := decoder.Token() // opening brace
+	if err != nil { return nil, err }
+	for decoder.More() {
+		_, err = decoder.Token() // frame filename
+		if err != nil { return nil, err }
+		var frame asepriteFrame
+		err = decoder.Decode(&frame)
+		if err != nil { return nil, err }
+		frames = append(frames, frame)
+	}
+	return frames, nil
+}
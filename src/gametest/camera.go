@@ -0,0 +1,137 @@
+package main
+
+import "fmt"
+import "math"
+import "image"
+
+import "github.com/tinne26/mipix"
+
+// Camera follower parameters, in pixels and ticks.
+const (
+	CameraDeadZoneWidth  = 24.0
+	CameraDeadZoneHeight = 36.0
+	CameraLookAhead = 24.0 // horizontal offset in the facing direction
+	CameraLookAheadSpeed = 0.4 // look-ahead offset change per tick
+)
+
+// The camera follower wraps the coordinates passed to
+// mipix.Camera().NotifyCoordinates(), adding a dead zone where
+// the target can move without the camera following, a look-ahead
+// offset towards the facing direction, and clamping to the level
+// bounds so the empty space beyond the level edges isn't shown.
+//
+// Bounds are applied to the camera area at the current zoom
+// level, so they adapt when zooming in or out. The top edge is
+// left open, as jumps can go above the highest graphics. If the
+// bounds are smaller than the camera area, the area is centered
+// on them instead.
+type CameraFollower struct {
+	DeadZoneWidth, DeadZoneHeight float64
+	LookAhead float64
+	LookAheadSpeed float64
+	bounds image.Rectangle // empty for no bounds
+	focusX, focusY float64 // dead zone center
+	lookAheadX float64
+}
+
+func NewCameraFollower(bounds image.Rectangle) *CameraFollower {
+	return &CameraFollower{
+		DeadZoneWidth: CameraDeadZoneWidth,
+		DeadZoneHeight: CameraDeadZoneHeight,
+		LookAhead: CameraLookAhead,
+		LookAheadSpeed: CameraLookAheadSpeed,
+		bounds: bounds,
+	}
+}
+
+func (self *CameraFollower) SetBounds(bounds image.Rectangle) {
+	self.bounds = bounds
+}
+
+// Moves the dead zone to contain the given target coordinates,
+// updates the look-ahead towards the given facing direction (-1,
+// 0 or 1) and notifies the resulting coordinates to the camera.
+func (self *CameraFollower) Update(x, y float64, facing int) {
+	for range mipix.Tick().GetRate() {
+		self.lookAheadX = approach(self.lookAheadX, float64(facing)*self.LookAhead, self.LookAheadSpeed)
+	}
+	halfWidth, halfHeight := self.DeadZoneWidth/2.0, self.DeadZoneHeight/2.0
+	self.focusX = min(max(self.focusX, x - halfWidth), x + halfWidth)
+	self.focusY = min(max(self.focusY, y - halfHeight), y + halfHeight)
+	mipix.Camera().NotifyCoordinates(self.Clamp(self.focusX + self.lookAheadX, self.focusY))
+}
+
+// Centers the dead zone on the given coordinates, discards the
+// look-ahead and resets the camera there, without any transition.
+func (self *CameraFollower) Reset(x, y float64) {
+	self.focusX, self.focusY = x, y
+	self.lookAheadX = 0
+	mipix.Camera().ResetCoordinates(self.Clamp(x, y))
+}
+
+// Camera state, used to save and restore it. The target
+// coordinates are the ones last notified to the camera.
+type CameraSnapshot struct {
+	FocusX float64 `json:"focus_x"`
+	FocusY float64 `json:"focus_y"`
+	LookAheadX float64 `json:"look_ahead_x"`
+	TargetX float64 `json:"target_x"`
+	TargetY float64 `json:"target_y"`
+	Zoom float64 `json:"zoom"` // target zoom level
+}
+
+func (self *CameraFollower) Snapshot() CameraSnapshot {
+	_, zoom := mipix.Camera().GetZoom()
+	targetX, targetY := self.Clamp(self.focusX + self.lookAheadX, self.focusY)
+	return CameraSnapshot{
+		FocusX: self.focusX, FocusY: self.focusY,
+		LookAheadX: self.lookAheadX,
+		TargetX: targetX, TargetY: targetY,
+		Zoom: zoom,
+	}
+}
+
+// Returns an error if the snapshot can't be restored.
+func (self *CameraSnapshot) Validate() error {
+	if self.Zoom < 0.05 || math.IsNaN(self.Zoom) || math.IsInf(self.Zoom, 0) {
+		return fmt.Errorf("invalid camera zoom %g", self.Zoom)
+	}
+	return nil
+}
+
+// Restores the camera state from a snapshot, setting the zoom
+// and resetting the camera to the target coordinates without
+// any transition. Zoom transitions still take a few ticks.
+func (self *CameraFollower) Restore(snapshot CameraSnapshot) error {
+	err := snapshot.Validate()
+	if err != nil { return err }
+	self.focusX, self.focusY = snapshot.FocusX, snapshot.FocusY
+	self.lookAheadX = snapshot.LookAheadX
+	mipix.Camera().Zoom(snapshot.Zoom)
+	mipix.Camera().ResetCoordinates(snapshot.TargetX, snapshot.TargetY)
+	return nil
+}
+
+// Returns the closest camera coordinates to the given ones that
+// keep the camera area within the bounds. The area size is taken
+// from the lowest of the current and target zoom levels, so the
+// bounds are also respected while zooming out.
+func (self *CameraFollower) Clamp(x, y float64) (float64, float64) {
+	if self.bounds.Empty() { return x, y }
+	current, target := mipix.Camera().GetZoom()
+	width, height := mipix.GetResolution()
+	halfWidth  := float64(width )/min(current, target)/2.0
+	halfHeight := float64(height)/min(current, target)/2.0
+	minX, maxX := float64(self.bounds.Min.X), float64(self.bounds.Max.X)
+	if maxX - minX <= halfWidth*2.0 {
+		x = (minX + maxX)/2.0
+	} else {
+		x = min(max(x, minX + halfWidth), maxX - halfWidth)
+	}
+	return x, min(y, float64(self.bounds.Max.Y) - halfHeight)
+}
+
+func approach(value, target, step float64) float64 {
+	if value < target { return min(value + step, target) }
+	return max(value - step, target)
+}
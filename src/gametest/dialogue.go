@@ -0,0 +1,133 @@
+package main
+
+import "io"
+import "fmt"
+import "bufio"
+import "strings"
+import "io/fs"
+
+// A dialogue is a graph of text nodes, where each node can
+// either continue to another node or offer a list of choices.
+//
+// Dialogue files are plain text, one command per line:
+//   # comments start with a hash
+//   node <id>
+//   text <text>
+//   next <id>
+//   choice <id> <text>
+//
+// Commands after "node" apply to that node. Multiple "text"
+// lines are joined with spaces. The first node is where the
+// dialogue starts, and the special id "end" closes it. Nodes
+// without "next" nor choices also close the dialogue.
+type Dialogue struct {
+	nodes map[string]*DialogueNode
+	start string
+}
+
+type DialogueNode struct {
+	Text string
+	Next string
+	Choices []DialogueChoice
+}
+
+type DialogueChoice struct {
+	Text string
+	Next string
+}
+
+const DialogueEnd = "end"
+
+func LoadDialogue(filesys fs.FS, path string) (*Dialogue, error) {
+	file, err := filesys.Open(path)
+	if err != nil { return nil, err }
+	dialogue, err := ParseDialogue(file, path)
+	closeErr := file.Close()
+	if err != nil { return nil, err }
+	return dialogue, closeErr
+}
+
+// Parses a dialogue from the given reader. The name is only
+// used to give context to error messages.
+func ParseDialogue(reader io.Reader, name string) (*Dialogue, error) {
+	dialogue := &Dialogue{ nodes: make(map[string]*DialogueNode, 4) }
+	var node *DialogueNode
+	scanner := bufio.NewScanner(reader)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum += 1
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i != -1 { line = line[ : i] }
+		command, args, _ := strings.Cut(strings.TrimSpace(line), " ")
+		args = strings.TrimSpace(args)
+		if command == "" { continue }
+
+		if command == "node" {
+			if args == "" || args == DialogueEnd || strings.ContainsAny(args, " \t") {
+				return nil, fmt.Errorf("%s:%d: invalid node id '%s'", name, lineNum, args)
+			}
+			if _, found := dialogue.nodes[args]; found {
+				return nil, fmt.Errorf("%s:%d: duplicate node '%s'", name, lineNum, args)
+			}
+			node = &DialogueNode{}
+			dialogue.nodes[args] = node
+			if dialogue.start == "" { dialogue.start = args }
+			continue
+		}
+		if node == nil {
+			return nil, fmt.Errorf("%s:%d: expected 'node <id>' before '%s'", name, lineNum, command)
+		}
+		switch command {
+		case "text":
+			if node.Text != "" { node.Text += " " }
+			node.Text += args
+		case "next":
+			if args == "" { return nil, fmt.Errorf("%s:%d: expected 'next <id>'", name, lineNum) }
+			node.Next = args
+		case "choice":
+			next, text, _ := strings.Cut(args, " ")
+			text = strings.TrimSpace(text)
+			if next == "" || text == "" {
+				return nil, fmt.Errorf("%s:%d: expected 'choice <id> <text>'", name, lineNum)
+			}
+			node.Choices = append(node.Choices, DialogueChoice{ Text: text, Next: next })
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown command '%s'", name, lineNum, command)
+		}
+	}
+	err := scanner.Err()
+	if err != nil { return nil, err }
+	if dialogue.start == "" { return nil, fmt.Errorf("%s: no dialogue nodes", name) }
+
+	// validate references
+	for id, node := range dialogue.nodes {
+		if node.Next != "" && len(node.Choices) > 0 {
+			return nil, fmt.Errorf("%s: node '%s' can't have both 'next' and choices", name, id)
+		}
+		if !dialogue.isValidTarget(node.Next) {
+			return nil, fmt.Errorf("%s: node '%s' references unknown node '%s'", name, id, node.Next)
+		}
+		for _, choice := range node.Choices {
+			if !dialogue.isValidTarget(choice.Next) {
+				return nil, fmt.Errorf("%s: node '%s' references unknown node '%s'", name, id, choice.Next)
+			}
+		}
+	}
+	return dialogue, nil
+}
+
+func (self *Dialogue) isValidTarget(id string) bool {
+	if id == "" || id == DialogueEnd { return true }
+	_, found := self.nodes[id]
+	return found
+}
+
+// Returns the node with the given id, or nil if the
+// id is empty, [DialogueEnd] or unknown.
+func (self *Dialogue) GetNode(id string) *DialogueNode {
+	return self.nodes[id]
+}
+
+func (self *Dialogue) GetStart() string {
+	return self.start
+}
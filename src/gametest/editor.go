@@ -0,0 +1,252 @@
+package main
+
+import "math"
+import "path"
+import "io/fs"
+import "strings"
+import "image"
+import "image/color"
+
+import "github.com/tinne26/mipix"
+import "github.com/tinne26/mipix/utils"
+import "github.com/hajimehoshi/ebiten/v2"
+import "github.com/hajimehoshi/ebiten/v2/inpututil"
+
+var EditorSelectRGB = utils.RGB(255, 22, 84)
+var EditorHoverRGB  = utils.RGBA(8, 103, 136, 160)
+
+// The editor allows picking, dragging, adding and deleting the
+// level graphics with the mouse, and saving the result back to
+// a level file. While the editor is active, the player is frozen
+// and the camera can be panned independently. Parallax layers
+// are edited in their own coordinates, at their current scroll
+// position, and only the first copy of repeated graphics can
+// be picked.
+type Editor struct {
+	active bool
+	palette []string // asset names that can be added to the level
+	paletteIndex int
+	layer int // index on the level layer names
+	selected int // index on the current layer, -1 if none
+	hovered int // index on the current layer, -1 if none
+	dragging bool
+	dragOffsetX, dragOffsetY int
+	camX, camY float64
+	savePath string
+	message string
+}
+
+// Creates a new editor that will save the level to the given path.
+func NewEditor(savePath string) *Editor {
+	names, err := fs.Glob(assets, "assets/*.png")
+	if err != nil { panic(err) }
+	palette := make([]string, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSuffix(path.Base(name), ".png")
+		if name == "player" { continue }
+		palette = append(palette, name)
+	}
+	return &Editor{ palette: palette, selected: -1, hovered: -1, savePath: savePath }
+}
+
+// Prepares the editor for a newly loaded level, which will be
+// saved to the given path. Layer indices and selections from
+// the previous level are dropped, as levels can have different
+// layers.
+func (self *Editor) Reset(savePath string) {
+	self.savePath = savePath
+	self.layer = 0
+	self.selected, self.hovered, self.dragging = -1, -1, false
+}
+
+func (self *Editor) IsActive() bool {
+	return self.active
+}
+
+// Enables or disables the editor. The given coordinates are
+// used as the initial camera position when enabling it.
+func (self *Editor) Toggle(camX, camY float64) {
+	self.active = !self.active
+	self.camX, self.camY = camX, camY
+	self.selected, self.hovered, self.dragging = -1, -1, false
+	self.message = ""
+}
+
+func (self *Editor) GetCameraCoords() (float64, float64) {
+	return self.camX, self.camY
+}
+
+func (self *Editor) Update(level *Level) {
+	graphics := self.layerGraphics(level)
+	lx, ly := mipix.Convert().ToLogicalCoords(ebiten.CursorPosition())
+	offset := self.layerOrigin(level).Sub(mipix.Camera().Area().Min)
+	x, y := int(math.Floor(lx)) + offset.X, int(math.Floor(ly)) + offset.Y
+	self.hovered = pickGraphic(*graphics, x, y)
+	ctrl := ebiten.IsKeyPressed(ebiten.KeyControl)
+	shift := ebiten.IsKeyPressed(ebiten.KeyShift)
+
+	// palette selection
+	_, wheelY := ebiten.Wheel()
+	if inpututil.IsKeyJustPressed(ebiten.KeyBracketRight) || wheelY < 0 {
+		self.paletteIndex = (self.paletteIndex + 1) % len(self.palette)
+	} else if inpututil.IsKeyJustPressed(ebiten.KeyBracketLeft) || wheelY > 0 {
+		self.paletteIndex = (self.paletteIndex + len(self.palette) - 1) % len(self.palette)
+	}
+
+	// layer switching, and moving the selection to the next layer
+	numLayers := len(level.GetLayerNames())
+	if inpututil.IsKeyJustPressed(ebiten.KeyL) {
+		self.layer = (self.layer + 1) % numLayers
+		self.selected, self.dragging = -1, false
+		return
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyM) && self.selected != -1 {
+		graphic := (*graphics)[self.selected]
+		*graphics = deleteGraphic(*graphics, self.selected)
+		prevOrigin := self.layerOrigin(level)
+		for { // skip layers that don't accept the graphic
+			self.layer = (self.layer + 1) % numLayers
+			if CheckLayerGraphic(self.layerName(level), graphic) == nil { break }
+		}
+		graphics = self.layerGraphics(level)
+		delta := self.layerOrigin(level).Sub(prevOrigin) // keep it in place on screen
+		graphic.X, graphic.Y = graphic.X + delta.X, graphic.Y + delta.Y
+		*graphics = append(*graphics, graphic)
+		self.selected, self.dragging = len(*graphics) - 1, false
+		return
+	}
+
+	// picking and dragging
+	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+		self.selected = self.hovered
+		if self.selected != -1 {
+			self.dragging = true
+			self.dragOffsetX = x - (*graphics)[self.selected].X
+			self.dragOffsetY = y - (*graphics)[self.selected].Y
+		}
+	}
+	if self.dragging {
+		if ebiten.IsMouseButtonPressed(ebiten.MouseButtonLeft) {
+			(*graphics)[self.selected].X = x - self.dragOffsetX
+			(*graphics)[self.selected].Y = y - self.dragOffsetY
+		} else {
+			self.dragging = false
+		}
+	}
+
+	// adding and deleting
+	if inpututil.IsKeyJustPressed(ebiten.KeyN) {
+		graphic := loadGraphic(self.palette[self.paletteIndex], x, y)
+		err := CheckLayerGraphic(self.layerName(level), graphic)
+		if err != nil {
+			self.message = err.Error()
+		} else {
+			*graphics = append(*graphics, graphic)
+			self.selected, self.dragging = len(*graphics) - 1, false
+		}
+	}
+	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonRight) && self.hovered != -1 {
+		self.deleteAt(graphics, self.hovered)
+	} else if inpututil.IsKeyJustPressed(ebiten.KeyDelete) && self.selected != -1 {
+		self.deleteAt(graphics, self.selected)
+	}
+
+	// nudging the selection pixel by pixel, or panning the camera
+	var dx, dy int
+	if editorKeyRepeat(ebiten.KeyArrowLeft)  { dx -= 1 }
+	if editorKeyRepeat(ebiten.KeyArrowRight) { dx += 1 }
+	if editorKeyRepeat(ebiten.KeyArrowUp)    { dy -= 1 }
+	if editorKeyRepeat(ebiten.KeyArrowDown)  { dy += 1 }
+	if shift || self.selected == -1 {
+		self.camX += float64(dx*4)
+		self.camY += float64(dy*4)
+	} else if !self.dragging {
+		(*graphics)[self.selected].X += dx
+		(*graphics)[self.selected].Y += dy
+	}
+
+	// saving
+	if ctrl && inpututil.IsKeyJustPressed(ebiten.KeyS) {
+		err := level.Save(self.savePath)
+		if err != nil {
+			self.message = "Save failed: " + err.Error()
+		} else {
+			self.message = "Saved to " + self.savePath
+		}
+	}
+}
+
+func (self *Editor) Draw(canvas *ebiten.Image, level *Level) {
+	mipix.Debug().Drawf("[TAB] Exit editor")
+	mipix.Debug().Drawf("[L] Layer: %s", self.layerName(level))
+	mipix.Debug().Drawf("[[/]] Palette: %s", self.palette[self.paletteIndex])
+	mipix.Debug().Drawf("[N] Add, [RMB/DEL] Delete")
+	mipix.Debug().Drawf("[M] Move to next layer")
+	mipix.Debug().Drawf("[ARROWS] Nudge, [SHIFT] Pan")
+	mipix.Debug().Drawf("[CTRL+S] Save")
+	if self.message != "" {
+		mipix.Debug().Drawf("%s", self.message)
+	}
+
+	graphics := *self.layerGraphics(level)
+	origin := self.layerOrigin(level)
+	if self.hovered != -1 && self.hovered != self.selected {
+		strokeRect(canvas, graphics[self.hovered].Bounds().Sub(origin), EditorHoverRGB)
+	}
+	if self.selected != -1 {
+		strokeRect(canvas, graphics[self.selected].Bounds().Sub(origin), EditorSelectRGB)
+	}
+}
+
+func (self *Editor) layerGraphics(level *Level) *[]Graphic {
+	return level.GetLayer(self.layerName(level))
+}
+
+func (self *Editor) layerName(level *Level) string {
+	return level.GetLayerNames()[self.layer]
+}
+
+// Returns the top-left corner of the visible area in
+// the current layer coordinates.
+func (self *Editor) layerOrigin(level *Level) image.Point {
+	parallax := level.GetParallaxLayer(self.layerName(level))
+	if parallax == nil { return mipix.Camera().Area().Min }
+	return parallax.GetOrigin()
+}
+
+func (self *Editor) deleteAt(graphics *[]Graphic, index int) {
+	*graphics = deleteGraphic(*graphics, index)
+	self.dragging = false
+	switch {
+	case self.selected == index : self.selected = -1
+	case self.selected  > index : self.selected -= 1
+	}
+	self.hovered = -1
+}
+
+// Returns the index of the topmost graphic containing the
+// given point, or -1 if none.
+func pickGraphic(graphics []Graphic, x, y int) int {
+	point := image.Pt(x, y)
+	for i := len(graphics) - 1; i >= 0; i-- {
+		if point.In(graphics[i].Bounds()) { return i }
+	}
+	return -1
+}
+
+func deleteGraphic(graphics []Graphic, index int) []Graphic {
+	return append(graphics[ : index], graphics[index + 1 : ]...)
+}
+
+func editorKeyRepeat(key ebiten.Key) bool {
+	ticks := inpututil.KeyPressDuration(key)
+	return ticks == 1 || (ticks > 20 && ticks % 4 == 0)
+}
+
+func strokeRect(canvas *ebiten.Image, rect image.Rectangle, clr color.Color) {
+	minX, minY, maxX, maxY := rect.Min.X, rect.Min.Y, rect.Max.X, rect.Max.Y
+	utils.FillOverRect(canvas, utils.Rect(minX - 1, minY - 1, maxX + 1, minY), clr)
+	utils.FillOverRect(canvas, utils.Rect(minX - 1, maxY, maxX + 1, maxY + 1), clr)
+	utils.FillOverRect(canvas, utils.Rect(minX - 1, minY, minX, maxY), clr)
+	utils.FillOverRect(canvas, utils.Rect(maxX, minY, maxX + 1, maxY), clr)
+}
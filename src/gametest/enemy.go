@@ -0,0 +1,173 @@
+package main
+
+import "math"
+import "image"
+import "strings"
+
+import "github.com/tinne26/mipix"
+import "github.com/hajimehoshi/ebiten/v2"
+
+// Enemy behaviour parameters, in pixels and ticks.
+const (
+	EnemyPatrolSpeed = 0.35
+	EnemyChaseSpeed = 0.8
+	EnemyPatrolRange = 40.0 // max horizontal distance from the spawn point while patrolling
+	EnemySightRange = 72.0 // horizontal distance at which the player is noticed
+	EnemySightHeight = 32.0 // vertical distance at which the player is noticed
+	EnemyIdleTicks = 90 // pause between patrol turns
+	EnemyHitIdleTicks = 45 // pause after hitting the player
+)
+
+// Returns whether the given graphic can be used as an enemy.
+func isEnemyGraphic(graphic Graphic) bool {
+	return strings.HasPrefix(graphic.Name, "skeleton_")
+}
+
+type EnemyState uint8
+const (
+	EnemyIdle EnemyState = iota
+	EnemyPatrol
+	EnemyChase
+)
+
+func (self EnemyState) String() string {
+	switch self {
+	case EnemyIdle   : return "idle"
+	case EnemyPatrol : return "patrol"
+	case EnemyChase  : return "chase"
+	default:
+		panic("invalid EnemyState")
+	}
+}
+
+// An enemy patrols around its spawn point, chases the player
+// when nearby and knocks the player back on contact. Enemies
+// never walk off ledges. Like the player, they are drawn at
+// high resolution.
+type Enemy struct {
+	Source *ebiten.Image
+	body Body
+	state EnemyState
+	direction int // -1 = left, 1 = right
+	idleTicksLeft int
+	originX float64
+	moving bool
+	crawlPhase float64 // for the crawling wobble
+}
+
+// The set of enemies in a level.
+type Enemies struct {
+	list []*Enemy
+}
+
+func NewEnemies(level *Level) *Enemies {
+	var enemies Enemies
+	for _, graphic := range level.Enemies {
+		bounds := graphic.Bounds()
+		enemy := &Enemy{ Source: graphic.Source, direction: -1, idleTicksLeft: EnemyIdleTicks }
+		enemy.body.X, enemy.body.Y = float64(bounds.Min.X), float64(bounds.Min.Y)
+		enemy.body.Width, enemy.body.Height = float64(bounds.Dx()), float64(bounds.Dy())
+		enemy.originX = enemy.body.X
+		enemies.list = append(enemies.list, enemy)
+	}
+	return &enemies
+}
+
+// Advances all enemies by one tick.
+func (self *Enemies) Update(world *World, player *Player) {
+	for _, enemy := range self.list {
+		enemy.Update(world, player)
+	}
+}
+
+func (self *Enemies) DrawHiRes(target *ebiten.Image) {
+	for _, enemy := range self.list {
+		enemy.DrawHiRes(target)
+	}
+}
+
+func (self *Enemy) Update(world *World, player *Player) {
+	dx := (player.body.X + player.body.Width/2.0) - (self.body.X + self.body.Width/2.0)
+	dy := (player.body.Y + player.body.Height) - (self.body.Y + self.body.Height)
+	seesPlayer := !player.IsDead() && math.Abs(dx) < EnemySightRange && math.Abs(dy) < EnemySightHeight
+
+	// behaviour
+	var speed float64
+	switch self.state {
+	case EnemyIdle:
+		self.idleTicksLeft -= 1
+		if seesPlayer {
+			self.state = EnemyChase
+		} else if self.idleTicksLeft <= 0 {
+			self.state = EnemyPatrol
+			self.direction = -self.direction
+		}
+	case EnemyPatrol:
+		if seesPlayer {
+			self.state = EnemyChase
+			break
+		}
+		distance := float64(self.direction)*(self.body.X - self.originX)
+		if distance >= EnemyPatrolRange || self.isLedgeAhead(world) {
+			self.setIdle(EnemyIdleTicks)
+		} else {
+			speed = EnemyPatrolSpeed
+		}
+	case EnemyChase:
+		if !seesPlayer {
+			self.setIdle(EnemyIdleTicks/2)
+			self.originX = self.body.X // patrol around the new position
+			break
+		}
+		if math.Abs(dx) > 1.0 {
+			self.direction = 1
+			if dx < 0 { self.direction = -1 }
+		}
+		if !self.isLedgeAhead(world) { speed = EnemyChaseSpeed }
+	}
+
+	// movement
+	self.moving = (speed != 0)
+	if self.moving {
+		blocked := self.body.MoveX(world, float64(self.direction)*speed)
+		if blocked && self.state == EnemyPatrol { self.setIdle(EnemyIdleTicks) }
+		self.crawlPhase += speed*0.5
+	}
+	self.body.VY = min(self.body.VY + PlayerGravity, PlayerMaxFallSpeed)
+	self.body.MoveY(world, self.body.VY)
+
+	// player contact
+	if player.body.Overlaps(self.body.Rect()) && player.CanBeHit() {
+		pushDir := 1
+		if dx < 0 { pushDir = -1 }
+		player.Knockback(pushDir)
+		self.setIdle(EnemyHitIdleTicks)
+	}
+}
+
+func (self *Enemy) setIdle(ticks int) {
+	self.state = EnemyIdle
+	self.idleTicksLeft = ticks
+}
+
+// Returns whether there's no ground right in front of the
+// enemy. Always false while airborne.
+func (self *Enemy) isLedgeAhead(world *World) bool {
+	if !self.body.OnGround { return false }
+	footX := int(math.Floor(self.body.X)) - 1
+	if self.direction == 1 { footX = int(math.Ceil(self.body.X + self.body.Width)) }
+	footY := int(math.Round(self.body.Y + self.body.Height))
+	return !world.HasSolidIn(image.Rect(footX, footY, footX + 1, footY + 2))
+}
+
+// Draws the enemy with a small vertical wobble while moving.
+// The graphic faces left by default.
+func (self *Enemy) DrawHiRes(target *ebiten.Image) {
+	y := self.body.Y
+	if self.moving { y -= math.Abs(math.Sin(self.crawlPhase)) }
+	if self.direction == 1 {
+		mipix.HiRes().DrawHorzFlip(target, self.Source, self.body.X, y)
+	} else {
+		mipix.HiRes().Draw(target, self.Source, self.body.X, y)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import "math"
+import "strings"
+
+import "github.com/tinne26/mipix"
+import "github.com/hajimehoshi/ebiten/v2"
+
+// Entity movement parameters, in pixels and ticks.
+const (
+	EntityGravity = 0.2
+	EntityMaxFallSpeed = 4.0
+	EntityFriction = 0.08 // horizontal speed lost per tick while on the ground
+	EntityKickSpeedX = 1.6
+	EntityKickSpeedY = 1.8
+)
+
+type EntityKind uint8
+const (
+	EntityWeapon EntityKind = iota // can be picked up and dropped
+	EntityProp // can be kicked around
+)
+
+// Returns the entity kind for the given graphic, if any.
+// Like solids, kinds are assigned by graphic name prefix.
+func getGraphicEntityKind(graphic Graphic) (EntityKind, bool) {
+	switch {
+	case strings.HasPrefix(graphic.Name, "sword_") : return EntityWeapon, true
+	case strings.HasPrefix(graphic.Name, "axe_")   : return EntityWeapon, true
+	case strings.HasPrefix(graphic.Name, "spear_") : return EntityWeapon, true
+	case strings.HasPrefix(graphic.Name, "skull_") : return EntityProp, true
+	default:
+		return 0, false
+	}
+}
+
+// An entity is a level graphic with its own body, affected by
+// gravity and able to interact with the player. Entities are
+// drawn at logical resolution while lying on the world, and
+// at high resolution while held by the player.
+type Entity struct {
+	Name string // graphic name
+	Kind EntityKind
+	Source *ebiten.Image
+	body Body
+	held bool
+}
+
+// The set of entities in a level.
+type Entities struct {
+	list []*Entity
+}
+
+func NewEntities(level *Level) *Entities {
+	var entities Entities
+	for _, graphic := range level.Entities {
+		kind, found := getGraphicEntityKind(graphic)
+		if !found { continue } // validated on level parsing
+		bounds := graphic.Bounds()
+		entity := &Entity{ Name: graphic.Name, Kind: kind, Source: graphic.Source }
+		entity.body.X, entity.body.Y = float64(bounds.Min.X), float64(bounds.Min.Y)
+		entity.body.Width, entity.body.Height = float64(bounds.Dx()), float64(bounds.Dy())
+		entities.list = append(entities.list, entity)
+	}
+	return &entities
+}
+
+// Picks up, swaps or drops weapons when the interact action
+// is pressed. This must be called once per update, not tick.
+func (self *Entities) HandleInput(player *Player, input *Input) {
+	if !input.JustPressed(ActionInteract) || player.IsDead() { return }
+	held := player.GetItem()
+	target := self.findWeapon(player)
+	if held != nil {
+		self.drop(held, player)
+		player.SetItem(nil)
+	}
+	if target != nil {
+		target.held = true
+		player.SetItem(target)
+	}
+}
+
+// Advances the entity physics by one tick.
+func (self *Entities) Update(world *World, player *Player) {
+	for _, entity := range self.list {
+		if entity.held { continue }
+		self.updateEntityTick(entity, world, player)
+	}
+	self.removeFallen(world)
+}
+
+// Returns the topmost weapon overlapping the player, if any.
+func (self *Entities) findWeapon(player *Player) *Entity {
+	for i := len(self.list) - 1; i >= 0; i-- {
+		entity := self.list[i]
+		if entity.held || entity.Kind != EntityWeapon { continue }
+		if player.body.Overlaps(entity.body.Rect()) { return entity }
+	}
+	return nil
+}
+
+// Releases the entity from the player's hand, letting it fall.
+func (self *Entities) drop(entity *Entity, player *Player) {
+	handX, handY := player.GetHandCoords()
+	entity.held = false
+	entity.body.X = handX - entity.body.Width/2.0
+	entity.body.Y = handY - entity.body.Height
+	entity.body.VX, entity.body.VY = 0, 0
+}
+
+func (self *Entities) updateEntityTick(entity *Entity, world *World, player *Player) {
+	body := &entity.body
+	if entity.Kind == EntityProp && body.OnGround && player.moving && player.body.Overlaps(body.Rect()) {
+		body.VX = float64(player.direction)*EntityKickSpeedX
+		body.VY = -EntityKickSpeedY
+	}
+	if body.OnGround {
+		if body.VX > 0 { body.VX = max(body.VX - EntityFriction, 0) }
+		if body.VX < 0 { body.VX = min(body.VX + EntityFriction, 0) }
+	}
+	body.MoveX(world, body.VX)
+	body.VY = min(body.VY + EntityGravity, EntityMaxFallSpeed)
+	body.MoveY(world, body.VY)
+}
+
+// Removes entities that fell off the bottom of the world.
+func (self *Entities) removeFallen(world *World) {
+	limit := float64(world.Bounds().Max.Y + GameHeight)
+	kept := self.list[ : 0]
+	for _, entity := range self.list {
+		if entity.held || entity.body.Y <= limit {
+			kept = append(kept, entity)
+		}
+	}
+	self.list = kept
+}
+
+// Draws the entities that are not held by the player.
+func (self *Entities) Draw(canvas *ebiten.Image) {
+	origin := mipix.Camera().Area().Min
+	var opts ebiten.DrawImageOptions
+	for _, entity := range self.list {
+		if entity.held { continue }
+		x, y := math.Round(entity.body.X), math.Round(entity.body.Y)
+		opts.GeoM.Translate(x - float64(origin.X), y - float64(origin.Y))
+		canvas.DrawImage(entity.Source, &opts)
+		opts.GeoM.Reset()
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import "strings"
+import "image"
+import "image/color"
+
+import "github.com/hajimehoshi/ebiten/v2"
+
+// Tiny built-in pixel font for the UI, with 3x5 uppercase
+// glyphs. Lowercase letters are drawn as uppercase and any
+// unsupported characters are drawn as '?'.
+const FontGlyphWidth, FontGlyphHeight = 3, 5
+const FontAdvance, FontLineHeight = 4, 7
+
+// Glyph rows from top to bottom, separated by spaces.
+var fontGlyphRows = map[rune]string{
+	'A': ".#. #.# ### #.# #.#", 'B': "##. #.# ##. #.# ##.", 'C': ".## #.. #.. #.. .##",
+	'D': "##. #.# #.# #.# ##.", 'E': "### #.. ##. #.. ###", 'F': "### #.. ##. #.. #..",
+	'G': ".## #.. #.# #.# .##", 'H': "#.# #.# ### #.# #.#", 'I': "### .#. .#. .#. ###",
+	'J': "..# ..# ..# #.# .#.", 'K': "#.# #.# ##. #.# #.#", 'L': "#.. #.. #.. #.. ###",
+	'M': "#.# ### ### #.# #.#", 'N': "##. #.# #.# #.# #.#", 'O': ".#. #.# #.# #.# .#.",
+	'P': "##. #.# ##. #.. #..", 'Q': ".#. #.# #.# ##. .##", 'R': "##. #.# ##. #.# #.#",
+	'S': ".## #.. .#. ..# ##.", 'T': "### .#. .#. .#. .#.", 'U': "#.# #.# #.# #.# ###",
+	'V': "#.# #.# #.# #.# .#.", 'W': "#.# #.# ### ### #.#", 'X': "#.# #.# .#. #.# #.#",
+	'Y': "#.# #.# .#. .#. .#.", 'Z': "### ..# .#. #.. ###",
+	'0': "### #.# #.# #.# ###", '1': ".#. ##. .#. .#. ###", '2': "##. ..# .#. #.. ###",
+	'3': "##. ..# .#. ..# ##.", '4': "#.# #.# ### ..# ..#", '5': "### #.. ##. ..# ##.",
+	'6': ".## #.. ### #.# ###", '7': "### ..# .#. .#. .#.", '8': "### #.# ### #.# ###",
+	'9': "### #.# ### ..# ##.",
+	' ': "... ... ... ... ...", '.': "... ... ... ... .#.", ',': "... ... ... .#. #..",
+	'!': ".#. .#. .#. ... .#.", '?': "##. ..# .#. ... .#.", '\'': ".#. .#. ... ... ...",
+	'"': "#.# #.# ... ... ...", '-': "... ... ### ... ...", '+': "... .#. ### .#. ...",
+	':': "... .#. ... .#. ...", ';': "... .#. ... .#. #..", '(': "..# .#. .#. .#. ..#",
+	')': "#.. .#. .#. .#. #..", '/': "..# ..# .#. #.. #..", '>': "#.. .#. ..# .#. #..",
+	'<': "..# .#. #.. .#. ..#", '=': "... ### ... ### ...", '_': "... ... ... ... ###",
+	'[': "##. #.. #.. #.. ##.", ']': ".## ..# ..# ..# .##",
+}
+
+var fontGlyphs map[rune]*ebiten.Image // created on first use
+
+func getFontGlyph(codePoint rune) *ebiten.Image {
+	if fontGlyphs == nil { fontGlyphs = newFontGlyphs() }
+	if codePoint >= 'a' && codePoint <= 'z' { codePoint -= 'a' - 'A' }
+	glyph, found := fontGlyphs[codePoint]
+	if !found { return fontGlyphs['?'] }
+	return glyph
+}
+
+// Creates a single white atlas with all the glyphs and
+// returns them as subimages.
+func newFontGlyphs() map[rune]*ebiten.Image {
+	atlas := image.NewRGBA(image.Rect(0, 0, len(fontGlyphRows)*FontGlyphWidth, FontGlyphHeight))
+	rects := make(map[rune]image.Rectangle, len(fontGlyphRows))
+	x := 0
+	for codePoint, glyph := range fontGlyphRows {
+		for row, pattern := range strings.Fields(glyph) {
+			for col, pixel := range pattern {
+				if pixel == '#' { atlas.Set(x + col, row, color.White) }
+			}
+		}
+		rects[codePoint] = image.Rect(x, 0, x + FontGlyphWidth, FontGlyphHeight)
+		x += FontGlyphWidth
+	}
+
+	source := ebiten.NewImageFromImage(atlas)
+	glyphs := make(map[rune]*ebiten.Image, len(rects))
+	for codePoint, rect := range rects {
+		glyphs[codePoint] = source.SubImage(rect).(*ebiten.Image)
+	}
+	return glyphs
+}
+
+// Draws a single line of text with its top-left corner at
+// the given coordinates.
+func drawText(target *ebiten.Image, text string, x, y int, clr color.Color) {
+	var opts ebiten.DrawImageOptions
+	opts.ColorScale.ScaleWithColor(clr)
+	for _, codePoint := range text {
+		if codePoint != ' ' {
+			opts.GeoM.Translate(float64(x), float64(y))
+			target.DrawImage(getFontGlyph(codePoint), &opts)
+			opts.GeoM.Reset()
+		}
+		x += FontAdvance
+	}
+}
+
+// Returns the width of a single line of text, in pixels.
+func measureText(text string) int {
+	count := len([]rune(text))
+	if count == 0 { return 0 }
+	return count*FontAdvance - (FontAdvance - FontGlyphWidth)
+}
+
+// Splits the text into lines that fit within the given width,
+// breaking at spaces. Words longer than the width are broken
+// wherever necessary.
+func wrapText(text string, maxWidth int) []string {
+	maxChars := max((maxWidth + FontAdvance - FontGlyphWidth)/FontAdvance, 1)
+	var lines []string
+	var line []rune
+	for _, word := range strings.Fields(text) {
+		runes := []rune(word)
+		for len(runes) > maxChars {
+			if len(line) > 0 {
+				lines = append(lines, string(line))
+				line = line[ : 0]
+			}
+			lines = append(lines, string(runes[ : maxChars]))
+			runes = runes[maxChars : ]
+		}
+		if len(line) > 0 && len(line) + 1 + len(runes) > maxChars {
+			lines = append(lines, string(line))
+			line = line[ : 0]
+		}
+		if len(line) > 0 { line = append(line, ' ') }
+		line = append(line, runes...)
+	}
+	if len(line) > 0 { lines = append(lines, string(line)) }
+	return lines
+}
@@ -0,0 +1,325 @@
+package main
+
+import "io"
+import "fmt"
+import "bufio"
+import "strings"
+import "io/fs"
+
+import "github.com/hajimehoshi/ebiten/v2"
+
+// Input actions, decoupled from the specific keys and
+// gamepad buttons bound to them.
+type Action uint8
+const (
+	ActionMoveLeft Action = iota
+	ActionMoveRight
+	ActionJump
+	ActionFullscreen
+	ActionFilterPrev
+	ActionFilterNext
+	ActionZoom
+	ActionShake
+	ActionEditor
+	ActionInteract
+	ActionUp // menu navigation
+	ActionDown // menu navigation
+	ActionSaveState
+	ActionLoadState
+	ActionRecordInput
+	ActionReplayInput
+	ActionParticleMode
+	ActionLightingMode
+	actionCount
+)
+
+var actionNames = [actionCount]string{
+	"move_left", "move_right", "jump", "fullscreen", "filter_prev",
+	"filter_next", "zoom", "shake", "editor", "interact",
+	"up", "down", "save_state", "load_state", "record_input",
+	"replay_input", "particle_mode", "lighting_mode",
+}
+
+func (self Action) String() string {
+	if self >= actionCount { panic("invalid Action") }
+	return actionNames[self]
+}
+
+// A set of actions, one bit per action.
+type InputState uint32
+
+func (self InputState) Has(action Action) bool {
+	return self & (1 << action) != 0
+}
+
+func (self InputState) With(action Action) InputState {
+	return self | (1 << action)
+}
+
+// Axis values beyond this threshold count as pressed.
+const InputAxisThreshold = 0.5
+
+type BindingKind uint8
+const (
+	BindingKey BindingKind = iota
+	BindingButton // standard gamepad layout button
+	BindingAxis   // standard gamepad layout axis, in one direction
+)
+
+// A binding is a key, gamepad button or gamepad axis direction
+// that triggers an action. Gamepads are only supported when they
+// have a standard layout mapping, so the same bindings work for
+// most controllers.
+//
+// In text form, bindings are written as "key:<name>", with key
+// names as in [ebiten.Key.String](), "button:<name>", with button
+// names as in the ebiten.StandardGamepadButton* constants without
+// the prefix, or "axis:<name><sign>", with axis names as in the
+// ebiten.StandardGamepadAxis* constants without the prefix and
+// sign being + or -. For example: "key:Space", "button:RightBottom"
+// or "axis:LeftStickHorizontal-".
+type Binding struct {
+	Kind BindingKind
+	Key ebiten.Key
+	Button ebiten.StandardGamepadButton
+	Axis ebiten.StandardGamepadAxis
+	AxisSign float64 // -1 or 1
+}
+
+var gamepadButtonNames = map[ebiten.StandardGamepadButton]string{
+	ebiten.StandardGamepadButtonRightBottom: "RightBottom",
+	ebiten.StandardGamepadButtonRightRight: "RightRight",
+	ebiten.StandardGamepadButtonRightLeft: "RightLeft",
+	ebiten.StandardGamepadButtonRightTop: "RightTop",
+	ebiten.StandardGamepadButtonFrontTopLeft: "FrontTopLeft",
+	ebiten.StandardGamepadButtonFrontTopRight: "FrontTopRight",
+	ebiten.StandardGamepadButtonFrontBottomLeft: "FrontBottomLeft",
+	ebiten.StandardGamepadButtonFrontBottomRight: "FrontBottomRight",
+	ebiten.StandardGamepadButtonCenterLeft: "CenterLeft",
+	ebiten.StandardGamepadButtonCenterRight: "CenterRight",
+	ebiten.StandardGamepadButtonLeftStick: "LeftStick",
+	ebiten.StandardGamepadButtonRightStick: "RightStick",
+	ebiten.StandardGamepadButtonLeftTop: "LeftTop",
+	ebiten.StandardGamepadButtonLeftBottom: "LeftBottom",
+	ebiten.StandardGamepadButtonLeftLeft: "LeftLeft",
+	ebiten.StandardGamepadButtonLeftRight: "LeftRight",
+	ebiten.StandardGamepadButtonCenterCenter: "CenterCenter",
+}
+
+var gamepadAxisNames = map[ebiten.StandardGamepadAxis]string{
+	ebiten.StandardGamepadAxisLeftStickHorizontal: "LeftStickHorizontal",
+	ebiten.StandardGamepadAxisLeftStickVertical: "LeftStickVertical",
+	ebiten.StandardGamepadAxisRightStickHorizontal: "RightStickHorizontal",
+	ebiten.StandardGamepadAxisRightStickVertical: "RightStickVertical",
+}
+
+func (self Binding) String() string {
+	switch self.Kind {
+	case BindingKey    : return "key:" + self.Key.String()
+	case BindingButton : return "button:" + gamepadButtonNames[self.Button]
+	case BindingAxis:
+		if self.AxisSign < 0 { return "axis:" + gamepadAxisNames[self.Axis] + "-" }
+		return "axis:" + gamepadAxisNames[self.Axis] + "+"
+	default:
+		panic("invalid BindingKind")
+	}
+}
+
+func ParseBinding(text string) (Binding, error) {
+	kind, name, found := strings.Cut(text, ":")
+	if !found { return Binding{}, fmt.Errorf("invalid binding '%s'", text) }
+	switch kind {
+	case "key":
+		var key ebiten.Key
+		err := key.UnmarshalText([]byte(name))
+		if err != nil { return Binding{}, fmt.Errorf("unknown key '%s'", name) }
+		return Binding{ Kind: BindingKey, Key: key }, nil
+	case "button":
+		for button, buttonName := range gamepadButtonNames {
+			if buttonName == name { return Binding{ Kind: BindingButton, Button: button }, nil }
+		}
+		return Binding{}, fmt.Errorf("unknown gamepad button '%s'", name)
+	case "axis":
+		var sign float64
+		switch {
+		case strings.HasSuffix(name, "+") : sign =  1
+		case strings.HasSuffix(name, "-") : sign = -1
+		default:
+			return Binding{}, fmt.Errorf("missing +/- sign on gamepad axis '%s'", name)
+		}
+		name = name[ : len(name) - 1]
+		for axis, axisName := range gamepadAxisNames {
+			if axisName == name { return Binding{ Kind: BindingAxis, Axis: axis, AxisSign: sign }, nil }
+		}
+		return Binding{}, fmt.Errorf("unknown gamepad axis '%s'", name)
+	default:
+		return Binding{}, fmt.Errorf("unknown binding kind '%s'", kind)
+	}
+}
+
+// The input maps keyboard and gamepad state to actions. It has
+// to be updated once per game update, before querying actions.
+//
+// Bindings files are plain text, one action per line:
+//   # comments start with a hash
+//   <action> <binding> [<binding> ...]
+//
+// Action names are the ones returned by [Action.String](). The
+// default bindings are at "assets/input.txt" on [assets].
+type Input struct {
+	bindings [actionCount][]Binding
+	pressed [actionCount]bool
+	prevPressed [actionCount]bool
+	gamepads []ebiten.GamepadID
+}
+
+// Creates an input with the default bindings.
+func NewInput() *Input {
+	var input Input
+	err := input.LoadBindings(assets, "assets/input.txt")
+	if err != nil { panic(err) }
+	return &input
+}
+
+// Loads bindings from the given filesystem. Actions that
+// appear in the file replace their previous bindings, while
+// the rest keep them.
+func (self *Input) LoadBindings(filesys fs.FS, path string) error {
+	file, err := filesys.Open(path)
+	if err != nil { return err }
+	err = self.ParseBindings(file, path)
+	closeErr := file.Close()
+	if err != nil { return err }
+	return closeErr
+}
+
+// Parses bindings from the given reader. The name is only
+// used to give context to error messages. On failure, the
+// current bindings are left unchanged.
+func (self *Input) ParseBindings(reader io.Reader, name string) error {
+	bindings := self.bindings
+	scanner := bufio.NewScanner(reader)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum += 1
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i != -1 { line = line[ : i] }
+		fields := strings.Fields(line)
+		if len(fields) == 0 { continue }
+
+		action, found := parseAction(fields[0])
+		if !found { return fmt.Errorf("%s:%d: unknown action '%s'", name, lineNum, fields[0]) }
+		actionBindings := make([]Binding, 0, len(fields) - 1)
+		for _, field := range fields[1 : ] {
+			binding, err := ParseBinding(field)
+			if err != nil { return fmt.Errorf("%s:%d: %w", name, lineNum, err) }
+			actionBindings = append(actionBindings, binding)
+		}
+		bindings[action] = actionBindings
+	}
+	err := scanner.Err()
+	if err != nil { return err }
+	self.bindings = bindings
+	return nil
+}
+
+// Writes the current bindings in the same text format
+// read by [Input.ParseBindings].
+func (self *Input) WriteBindings(writer io.Writer) error {
+	_, err := fmt.Fprint(writer, "# <action> <binding> [<binding> ...]\n")
+	if err != nil { return err }
+	for action := range actionCount {
+		_, err = fmt.Fprint(writer, action.String())
+		if err != nil { return err }
+		for _, binding := range self.bindings[action] {
+			_, err = fmt.Fprint(writer, " ", binding.String())
+			if err != nil { return err }
+		}
+		_, err = fmt.Fprint(writer, "\n")
+		if err != nil { return err }
+	}
+	return nil
+}
+
+func parseAction(name string) (Action, bool) {
+	for action := range actionCount {
+		if actionNames[action] == name { return action, true }
+	}
+	return 0, false
+}
+
+// Refreshes the pressed state of all actions.
+func (self *Input) Update() {
+	self.gamepads = ebiten.AppendGamepadIDs(self.gamepads[ : 0])
+	self.prevPressed = self.pressed
+	for action := range actionCount {
+		self.pressed[action] = false
+		for _, binding := range self.bindings[action] {
+			if self.isBindingPressed(binding) {
+				self.pressed[action] = true
+				break
+			}
+		}
+	}
+}
+
+func (self *Input) isBindingPressed(binding Binding) bool {
+	if binding.Kind == BindingKey { return ebiten.IsKeyPressed(binding.Key) }
+	for _, id := range self.gamepads {
+		if !ebiten.IsStandardGamepadLayoutAvailable(id) { continue }
+		switch binding.Kind {
+		case BindingButton:
+			if ebiten.IsStandardGamepadButtonPressed(id, binding.Button) { return true }
+		case BindingAxis:
+			value := ebiten.StandardGamepadAxisValue(id, binding.Axis)
+			if value*binding.AxisSign > InputAxisThreshold { return true }
+		}
+	}
+	return false
+}
+
+// Returns the set of actions pressed on the last update.
+func (self *Input) State() InputState {
+	var state InputState
+	for action := range actionCount {
+		if self.pressed[action] { state = state.With(action) }
+	}
+	return state
+}
+
+// Overrides the pressed state of the actions in the mask with
+// the given state. Used to replay recorded input through the
+// same code that handles live input. This must be called right
+// after [Input.Update]().
+func (self *Input) ReplaceState(state InputState, mask InputState) {
+	for action := range actionCount {
+		if mask.Has(action) { self.pressed[action] = state.Has(action) }
+	}
+}
+
+func (self *Input) Pressed(action Action) bool {
+	return self.pressed[action]
+}
+
+// Returns whether the action was pressed on the last update,
+// but not on the previous one.
+func (self *Input) JustPressed(action Action) bool {
+	return self.pressed[action] && !self.prevPressed[action]
+}
+
+// Returns the upper case names of the first key bound to each
+// of the given actions, separated by slashes, for help texts.
+func (self *Input) KeyLabel(actions ...Action) string {
+	var label strings.Builder
+	for _, action := range actions {
+		name := "?"
+		for _, binding := range self.bindings[action] {
+			if binding.Kind != BindingKey { continue }
+			name = strings.ToUpper(binding.Key.String())
+			break
+		}
+		if label.Len() > 0 { label.WriteByte('/') }
+		label.WriteString(name)
+	}
+	return label.String()
+}
@@ -0,0 +1,337 @@
+package main
+
+import "os"
+import "io"
+import "fmt"
+import "bufio"
+import "errors"
+import "strings"
+import "strconv"
+import "image"
+import "io/fs"
+
+// A level is a list of graphics split into draw layers,
+// plus the player spawn point and checkpoints.
+//
+// Level files are plain text, one entry per line:
+//   # comments start with a hash
+//   <layer> <graphic_name> <x> <y>
+//   spawn <x> <y>
+//   checkpoint <x> <y>
+//   exit <left|right> <level> <y> [fade|dissolve|wipe]
+//   layer <name> <factor> [anchor <x> <y>] [repeat <width>]
+//
+// The graphic name is the asset file name without the
+// ".png" extension. Valid layers are "back" (drawn behind
+// the player), "front" (drawn over the player), "entity"
+// (weapons and props the player can interact with, see
+// [Entity]), "enemy" (see [Enemy]) and any parallax layer
+// declared earlier in the file with "layer" (see
+// [ParallaxLayer]). Spawn and checkpoint coordinates
+// refer to the top-left corner of the player frame. Exits
+// are described in [LevelExit].
+type Level struct {
+	Back  []Graphic
+	Front []Graphic
+	Entities []Graphic
+	Enemies []Graphic
+	Parallax []*ParallaxLayer // in draw order
+	Spawn image.Point
+	Checkpoints []image.Point
+	Exits []LevelExit
+}
+
+// An exit leads to another level when the player walks past
+// the left or right edge of the level bounds. The y coordinate
+// is where the top of the player frame is placed when entering
+// the level through the same edge, so exits are expected to be
+// declared in pairs on the connected levels. The transition
+// defaults to [TransitionFade].
+type LevelExit struct {
+	Side int // -1 for the left edge, 1 for the right edge
+	Level string // path relative to the directory of the level declaring it
+	Y int
+	Transition TransitionKind
+}
+
+func (self *LevelExit) SideName() string {
+	return sideName(self.Side)
+}
+
+func sideName(side int) string {
+	if side == -1 { return "left" }
+	return "right"
+}
+
+// Loads a level file from the given filesystem. The embedded
+// default level is at "assets/levels/main.txt" on [assets].
+func LoadLevel(filesys fs.FS, path string) (*Level, error) {
+	file, err := filesys.Open(path)
+	if err != nil { return nil, err }
+	level, err := ParseLevel(file, path)
+	closeErr := file.Close()
+	if err != nil { return nil, err }
+	return level, closeErr
+}
+
+// Parses a level from the given reader. The name is only
+// used to give context to error messages.
+func ParseLevel(reader io.Reader, name string) (*Level, error) {
+	var level Level
+	scanner := bufio.NewScanner(reader)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum += 1
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i != -1 { line = line[ : i] }
+		fields := strings.Fields(line)
+		if len(fields) == 0 { continue }
+
+		switch fields[0] {
+		case "spawn", "checkpoint":
+			point, err := parsePointEntry(fields)
+			if err != nil { return nil, fmt.Errorf("%s:%d: %w", name, lineNum, err) }
+			if fields[0] == "spawn" {
+				level.Spawn = point
+			} else {
+				level.Checkpoints = append(level.Checkpoints, point)
+			}
+			continue
+		case "exit":
+			exit, err := parseExitEntry(fields)
+			if err != nil { return nil, fmt.Errorf("%s:%d: %w", name, lineNum, err) }
+			if _, found := level.GetExit(exit.Side); found {
+				return nil, fmt.Errorf("%s:%d: duplicate %s exit", name, lineNum, exit.SideName())
+			}
+			level.Exits = append(level.Exits, exit)
+			continue
+		case "layer":
+			parallax, err := parseParallaxEntry(fields)
+			if err != nil { return nil, fmt.Errorf("%s:%d: %w", name, lineNum, err) }
+			if level.GetLayer(parallax.Name) != nil {
+				return nil, fmt.Errorf("%s:%d: duplicate layer '%s'", name, lineNum, parallax.Name)
+			}
+			level.Parallax = append(level.Parallax, parallax)
+			continue
+		}
+		layer := level.GetLayer(fields[0])
+		if layer == nil { return nil, fmt.Errorf("%s:%d: unknown layer '%s'", name, lineNum, fields[0]) }
+		graphic, err := parseGraphicEntry(fields)
+		if err == nil { err = CheckLayerGraphic(fields[0], graphic) }
+		if err != nil { return nil, fmt.Errorf("%s:%d: %w", name, lineNum, err) }
+		*layer = append(*layer, graphic)
+	}
+	err := scanner.Err()
+	if err != nil { return nil, err }
+	return &level, nil
+}
+
+// Returns the union of the back and front graphic bounds.
+// Parallax layers are not included.
+func (self *Level) Bounds() image.Rectangle {
+	var bounds image.Rectangle
+	for _, layer := range [][]Graphic{ self.Back, self.Front } {
+		for _, graphic := range layer {
+			bounds = bounds.Union(graphic.Bounds())
+		}
+	}
+	return bounds
+}
+
+// Returns the exit on the given side (-1 for left, 1 for
+// right), if any.
+func (self *Level) GetExit(side int) (LevelExit, bool) {
+	for _, exit := range self.Exits {
+		if exit.Side == side { return exit, true }
+	}
+	return LevelExit{}, false
+}
+
+// Returns the parallax layer with the given name, or nil if
+// the level doesn't have any such layer.
+func (self *Level) GetParallaxLayer(name string) *ParallaxLayer {
+	for _, parallax := range self.Parallax {
+		if parallax.Name == name { return parallax }
+	}
+	return nil
+}
+
+// Returns the graphics of the named layer, or nil if the
+// level doesn't have any such layer.
+func (self *Level) GetLayer(name string) *[]Graphic {
+	switch name {
+	case "back"   : return &self.Back
+	case "front"  : return &self.Front
+	case "entity" : return &self.Entities
+	case "enemy"  : return &self.Enemies
+	}
+	parallax := self.GetParallaxLayer(name)
+	if parallax == nil { return nil }
+	return &parallax.Graphics
+}
+
+// Returns the names of all the level layers, with the
+// fixed layers first and parallax layers after them.
+func (self *Level) GetLayerNames() []string {
+	names := []string{ "back", "front", "entity", "enemy" }
+	for _, parallax := range self.Parallax {
+		names = append(names, parallax.Name)
+	}
+	return names
+}
+
+// Returns an error if the graphic can't be placed on the
+// given layer. Entity and enemy layers only accept specific
+// graphics, while the rest accept any.
+func CheckLayerGraphic(layer string, graphic Graphic) error {
+	switch layer {
+	case "entity":
+		_, found := getGraphicEntityKind(graphic)
+		if !found { return fmt.Errorf("graphic '%s' can't be an entity", graphic.Name) }
+	case "enemy":
+		if !isEnemyGraphic(graphic) { return fmt.Errorf("graphic '%s' can't be an enemy", graphic.Name) }
+	}
+	return nil
+}
+
+func parsePointEntry(fields []string) (image.Point, error) {
+	if len(fields) != 3 {
+		return image.Point{}, fmt.Errorf("expected '%s <x> <y>', found %d fields", fields[0], len(fields))
+	}
+	x, err := strconv.Atoi(fields[1])
+	if err != nil { return image.Point{}, fmt.Errorf("invalid x coordinate '%s'", fields[1]) }
+	y, err := strconv.Atoi(fields[2])
+	if err != nil { return image.Point{}, fmt.Errorf("invalid y coordinate '%s'", fields[2]) }
+	return image.Pt(x, y), nil
+}
+
+func parseExitEntry(fields []string) (LevelExit, error) {
+	if len(fields) != 4 && len(fields) != 5 {
+		return LevelExit{}, fmt.Errorf("expected 'exit <left|right> <level> <y> [<transition>]', found %d fields", len(fields))
+	}
+	var exit LevelExit
+	switch fields[1] {
+	case "left"  : exit.Side = -1
+	case "right" : exit.Side =  1
+	default:
+		return LevelExit{}, fmt.Errorf("invalid exit side '%s'", fields[1])
+	}
+	exit.Level = fields[2]
+	y, err := strconv.Atoi(fields[3])
+	if err != nil { return LevelExit{}, fmt.Errorf("invalid y coordinate '%s'", fields[3]) }
+	exit.Y = y
+	if len(fields) == 5 {
+		transition, found := parseTransitionKind(fields[4])
+		if !found { return LevelExit{}, fmt.Errorf("unknown transition '%s'", fields[4]) }
+		exit.Transition = transition
+	}
+	return exit, nil
+}
+
+func parseParallaxEntry(fields []string) (*ParallaxLayer, error) {
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("expected 'layer <name> <factor> [anchor <x> <y>] [repeat <width>]', found %d fields", len(fields))
+	}
+	switch fields[1] {
+	case "spawn", "checkpoint", "exit", "layer":
+		return nil, fmt.Errorf("invalid layer name '%s'", fields[1])
+	}
+	factor, err := strconv.ParseFloat(fields[2], 64)
+	if err != nil || factor < 0 { return nil, fmt.Errorf("invalid scroll factor '%s'", fields[2]) }
+	parallax := &ParallaxLayer{ Name: fields[1], Factor: factor }
+	options := fields[3 : ]
+	for len(options) > 0 {
+		switch options[0] {
+		case "anchor":
+			if len(options) < 3 { return nil, fmt.Errorf("expected 'anchor <x> <y>'") }
+			point, err := parsePointEntry(options[ : 3])
+			if err != nil { return nil, err }
+			parallax.AnchorX, parallax.AnchorY = point.X, point.Y
+			options = options[3 : ]
+		case "repeat":
+			if len(options) < 2 { return nil, fmt.Errorf("expected 'repeat <width>'") }
+			width, err := strconv.Atoi(options[1])
+			if err != nil || width <= 0 { return nil, fmt.Errorf("invalid repeat width '%s'", options[1]) }
+			parallax.RepeatWidth = width
+			options = options[2 : ]
+		default:
+			return nil, fmt.Errorf("unknown layer option '%s'", options[0])
+		}
+	}
+	return parallax, nil
+}
+
+func parseGraphicEntry(fields []string) (Graphic, error) {
+	if len(fields) != 4 {
+		return Graphic{}, fmt.Errorf("expected '<layer> <graphic_name> <x> <y>', found %d fields", len(fields))
+	}
+	x, err := strconv.Atoi(fields[2])
+	if err != nil { return Graphic{}, fmt.Errorf("invalid x coordinate '%s'", fields[2]) }
+	y, err := strconv.Atoi(fields[3])
+	if err != nil { return Graphic{}, fmt.Errorf("invalid y coordinate '%s'", fields[3]) }
+	graphic, err := tryLoadGraphic(fields[1], x, y)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Graphic{}, fmt.Errorf("unknown graphic '%s'", fields[1])
+	}
+	return graphic, err
+}
+
+// Writes the level in the same text format read by [ParseLevel].
+func (self *Level) Write(writer io.Writer) error {
+	_, err := fmt.Fprint(writer, "# <layer> <graphic_name> <x> <y>\n")
+	if err != nil { return err }
+	_, err = fmt.Fprintf(writer, "spawn %d %d\n", self.Spawn.X, self.Spawn.Y)
+	if err != nil { return err }
+	for _, point := range self.Checkpoints {
+		_, err = fmt.Fprintf(writer, "checkpoint %d %d\n", point.X, point.Y)
+		if err != nil { return err }
+	}
+	for _, exit := range self.Exits {
+		_, err = fmt.Fprintf(writer, "exit %s %s %d %s\n", exit.SideName(), exit.Level, exit.Y, exit.Transition.String())
+		if err != nil { return err }
+	}
+	for _, parallax := range self.Parallax {
+		_, err = fmt.Fprintf(writer, "layer %s %s anchor %d %d", parallax.Name,
+			strconv.FormatFloat(parallax.Factor, 'f', -1, 64), parallax.AnchorX, parallax.AnchorY)
+		if err != nil { return err }
+		if parallax.RepeatWidth > 0 {
+			_, err = fmt.Fprintf(writer, " repeat %d", parallax.RepeatWidth)
+			if err != nil { return err }
+		}
+		_, err = fmt.Fprint(writer, "\n")
+		if err != nil { return err }
+	}
+	for _, graphic := range self.Back {
+		_, err = fmt.Fprintf(writer, "back %s %d %d\n", graphic.Name, graphic.X, graphic.Y)
+		if err != nil { return err }
+	}
+	for _, graphic := range self.Front {
+		_, err = fmt.Fprintf(writer, "front %s %d %d\n", graphic.Name, graphic.X, graphic.Y)
+		if err != nil { return err }
+	}
+	for _, graphic := range self.Entities {
+		_, err = fmt.Fprintf(writer, "entity %s %d %d\n", graphic.Name, graphic.X, graphic.Y)
+		if err != nil { return err }
+	}
+	for _, graphic := range self.Enemies {
+		_, err = fmt.Fprintf(writer, "enemy %s %d %d\n", graphic.Name, graphic.X, graphic.Y)
+		if err != nil { return err }
+	}
+	for _, parallax := range self.Parallax {
+		for _, graphic := range parallax.Graphics {
+			_, err = fmt.Fprintf(writer, "%s %s %d %d\n", parallax.Name, graphic.Name, graphic.X, graphic.Y)
+			if err != nil { return err }
+		}
+	}
+	return nil
+}
+
+// Saves the level to the given file path.
+func (self *Level) Save(path string) error {
+	file, err := os.Create(path)
+	if err != nil { return err }
+	err = self.Write(file)
+	closeErr := file.Close()
+	if err != nil { return err }
+	return closeErr
+}
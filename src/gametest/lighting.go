@@ -0,0 +1,207 @@
+package main
+
+import "math"
+import "image"
+import "image/color"
+
+import "github.com/tinne26/mipix"
+import "github.com/tinne26/mipix/utils"
+import "github.com/hajimehoshi/ebiten/v2"
+
+var LightingDarknessRGBA = utils.RGBA(14, 8, 22, 220)
+
+// Light glows are added on top of the scene, so their alpha
+// is left at zero and they can't go through utils.RGBA().
+var TorchGlowRGBA = color.RGBA{ 44, 22, 4, 0 }
+var SwordGlowRGBA = color.RGBA{ 26, 12, 48, 0 }
+
+// Lighting parameters, in logical pixels and ticks.
+const (
+	TorchRadius = 44.0
+	TorchFlicker = 0.05 // max radius variation, relative
+	SwordGlowRadius = 30.0
+	SwordGlowPulse = 0.12 // max radius variation, relative
+	SwordGlowPulseTicks = 150 // pulse period
+	LightFalloffSize = 64 // falloff texture size, in pixels
+)
+
+type LightingMode uint8
+const (
+	LightingOff     LightingMode = iota
+	LightingLogical // darkness computed per logical pixel
+	LightingHiRes   // darkness computed per high resolution pixel
+	lightingModeCount
+)
+
+func (self LightingMode) String() string {
+	switch self {
+	case LightingOff     : return "off"
+	case LightingLogical : return "logical"
+	case LightingHiRes   : return "hi-res"
+	default:
+		panic("invalid LightingMode")
+	}
+}
+
+// A radial light source, fully lit at its center and fading
+// smoothly towards its radius.
+type Light struct {
+	X, Y float64 // center, in logical coordinates
+	Radius float64
+	Glow color.RGBA // added over the lit area, premultiplied alpha
+}
+
+// Lighting covers the scene with darkness, except where light
+// sources cut through it. The darkness can be computed either
+// on the logical canvas, where it's scaled along the rest of
+// the scene, or directly at high resolution, to compare how
+// each option holds up under zooms and the different scaling
+// filters.
+//
+// The player carries a flickering torch, and the absorbed
+// large swords on the level glow softly. Both are driven by
+// the tick count instead of randomness, so input replays
+// look the same.
+type Lighting struct {
+	mode LightingMode
+	swords []Light
+	ticks int
+	falloff *ebiten.Image
+	buffer *ebiten.Image // darkness composition, reused between draws
+	lights []Light // reused between draws
+}
+
+func NewLighting(level *Level) *Lighting {
+	lighting := &Lighting{ falloff: newLightFalloff(LightFalloffSize) }
+	lighting.Reset(level)
+	return lighting
+}
+
+// Sets the static lights from the given level and restarts
+// the light animations.
+func (self *Lighting) Reset(level *Level) {
+	self.swords = self.swords[ : 0]
+	for _, layer := range [][]Graphic{ level.Back, level.Front } {
+		for _, graphic := range layer {
+			if graphic.Name != "large_sword_absorbed" { continue }
+			bounds := graphic.Bounds()
+			self.swords = append(self.swords, Light{
+				X: float64(bounds.Min.X + bounds.Max.X)/2.0,
+				Y: float64(bounds.Min.Y + bounds.Max.Y)/2.0,
+				Radius: SwordGlowRadius,
+				Glow: SwordGlowRGBA,
+			})
+		}
+	}
+	self.ticks = 0
+}
+
+func (self *Lighting) GetMode() LightingMode {
+	return self.mode
+}
+
+// Cycles between off, logical and high resolution lighting.
+func (self *Lighting) NextMode() {
+	self.mode = (self.mode + 1) % lightingModeCount
+}
+
+// Advances the light animations by one tick.
+func (self *Lighting) Update() {
+	self.ticks += 1
+}
+
+// Draws the darkness and lights on the logical canvas. The
+// player is only used to place the torch.
+func (self *Lighting) Draw(canvas *ebiten.Image, player *Player) {
+	area := mipix.Camera().Area()
+	self.compose(canvas, player, float64(area.Min.X), float64(area.Min.Y), 1.0)
+}
+
+// Draws the darkness and lights at high resolution.
+func (self *Lighting) DrawHiRes(target *ebiten.Image, player *Player) {
+	minX, minY, maxX, _ := mipix.Camera().AreaF64()
+	scale := float64(target.Bounds().Dx())/(maxX - minX)
+	self.compose(target, player, minX, minY, scale)
+}
+
+// Fills the buffer with darkness, cuts out the lights and
+// projects the result to the target, adding the light glows
+// on top. The given coordinates are the logical point mapped
+// to the top-left corner of the target, and the scale is the
+// number of target pixels per logical pixel.
+func (self *Lighting) compose(target *ebiten.Image, player *Player, minX, minY, scale float64) {
+	self.lights = append(self.lights[ : 0], self.swords...)
+	pulse := 1.0 + SwordGlowPulse*math.Sin(2.0*math.Pi*float64(self.ticks)/SwordGlowPulseTicks)
+	for i := range self.lights {
+		self.lights[i].Radius *= pulse
+	}
+	x, y := player.GetHandCoords()
+	t := float64(self.ticks)
+	flicker := 1.0 + TorchFlicker*(0.6*math.Sin(t*0.23) + 0.4*math.Sin(t*0.61 + 1.3))
+	self.lights = append(self.lights, Light{ X: x, Y: y, Radius: TorchRadius*flicker, Glow: TorchGlowRGBA })
+
+	bounds := target.Bounds()
+	buffer := self.getBuffer(bounds.Dx(), bounds.Dy())
+	buffer.Fill(LightingDarknessRGBA)
+	var opts ebiten.DrawImageOptions
+	opts.Filter = ebiten.FilterLinear
+	opts.Blend = ebiten.BlendDestinationOut
+	for _, light := range self.lights {
+		self.setLightGeoM(&opts.GeoM, light, minX, minY, scale)
+		buffer.DrawImage(self.falloff, &opts)
+		opts.GeoM.Reset()
+	}
+
+	opts.Blend = ebiten.BlendSourceOver
+	opts.GeoM.Translate(float64(bounds.Min.X), float64(bounds.Min.Y))
+	target.DrawImage(buffer, &opts)
+	opts.GeoM.Reset()
+
+	opts.Blend = ebiten.BlendLighter
+	for _, light := range self.lights {
+		self.setLightGeoM(&opts.GeoM, light, minX, minY, scale)
+		opts.GeoM.Translate(float64(bounds.Min.X), float64(bounds.Min.Y))
+		opts.ColorScale.ScaleWithColor(light.Glow)
+		target.DrawImage(self.falloff, &opts)
+		opts.ColorScale.Reset()
+		opts.GeoM.Reset()
+	}
+}
+
+func (self *Lighting) setLightGeoM(geom *ebiten.GeoM, light Light, minX, minY, scale float64) {
+	size := light.Radius*2.0*scale
+	geom.Scale(size/LightFalloffSize, size/LightFalloffSize)
+	geom.Translate((light.X - light.Radius - minX)*scale, (light.Y - light.Radius - minY)*scale)
+}
+
+// Returns a buffer of the given size, reallocating the
+// underlying image only when it's not big enough.
+func (self *Lighting) getBuffer(width, height int) *ebiten.Image {
+	if self.buffer != nil {
+		bounds := self.buffer.Bounds()
+		if bounds.Dx() < width || bounds.Dy() < height {
+			self.buffer.Deallocate()
+			self.buffer = ebiten.NewImage(max(width, bounds.Dx()), max(height, bounds.Dy()))
+		}
+	} else {
+		self.buffer = ebiten.NewImage(width, height)
+	}
+	return self.buffer.SubImage(image.Rect(0, 0, width, height)).(*ebiten.Image)
+}
+
+// Creates a white radial gradient, opaque at the center and
+// fading smoothly to transparent at the edges.
+func newLightFalloff(size int) *ebiten.Image {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	center := float64(size)/2.0
+	for y := range size {
+		for x := range size {
+			dx, dy := float64(x) + 0.5 - center, float64(y) + 0.5 - center
+			t := min(math.Sqrt(dx*dx + dy*dy)/center, 1.0)
+			t = 1.0 - t*t
+			alpha := uint8(math.Round(255*t*t*(3.0 - 2.0*t))) // smoothstep
+			img.SetRGBA(x, y, color.RGBA{ alpha, alpha, alpha, alpha })
+		}
+	}
+	return ebiten.NewImageFromImage(img)
+}
@@ -1,13 +1,18 @@
 package main
 
+import "os"
+import "fmt"
+import "flag"
 import "embed"
 import "image"
 import "image/png"
 import "image/color"
+import "io/fs"
+import "math/rand/v2"
+import "path/filepath"
 
 import "github.com/tinne26/mipix"
 import "github.com/hajimehoshi/ebiten/v2"
-import "github.com/hajimehoshi/ebiten/v2/inpututil"
 
 //go:embed assets/*
 var assets embed.FS // see assets/README.md for licensing
@@ -17,157 +22,86 @@ const GameWidth, GameHeight = 256, 144
 // --- graphic ---
 
 type Graphic struct {
+	Name string
 	X, Y int
 	Source *ebiten.Image
 }
 
+func (self *Graphic) Bounds() image.Rectangle {
+	return self.Source.Bounds().Sub(self.Source.Bounds().Min).Add(image.Pt(self.X, self.Y))
+}
+
 var graphicSources map[string]*ebiten.Image = make(map[string]*ebiten.Image, 32)
 func loadGraphic(name string, x, y int) Graphic {
+	graphic, err := tryLoadGraphic(name, x, y)
+	if err != nil { panic(err) }
+	return graphic
+}
+
+func tryLoadGraphic(name string, x, y int) (Graphic, error) {
 	source, found := graphicSources[name]
 	if !found {
-		file, err := assets.Open("assets/" + name + ".png")
-		if err != nil { panic(err) }
-		img, err := png.Decode(file)
-		if err != nil { panic(err) }
-		source = ebiten.NewImageFromImage(img)
-		err = file.Close()
-		if err != nil { panic(err) }
+		var err error
+		source, err = loadImage(assets, "assets/" + name + ".png")
+		if err != nil { return Graphic{}, err }
 		graphicSources[name] = source
 	}
-	return Graphic{ X: x, Y: y, Source: source }
-}
-
-// --- animation ---
-
-type Animation struct {
-	frames []*ebiten.Image
-	frameDurations []uint8
-	frameDurationLeft uint8
-	frameIndex, loopIndex uint8
-}
-func (self *Animation) AddFrame(frame *ebiten.Image, durationTicks uint8) {
-	if durationTicks == 0 { panic("durationTicks == 0") }
-	self.frames = append(self.frames, frame)
-	self.frameDurations = append(self.frameDurations, durationTicks)
-	if len(self.frames) == 1 { self.frameDurationLeft = durationTicks }
-}
-func (self *Animation) Update() {
-	self.frameDurationLeft -= 1
-	if self.frameDurationLeft == 0 {
-		if self.frameIndex == uint8(len(self.frames) - 1) {
-			self.frameIndex = self.loopIndex
-		} else {
-			self.frameIndex += 1
-		}
-		self.frameDurationLeft = self.frameDurations[self.frameIndex]
-	}
-}
-func (self *Animation) GetFrame() *ebiten.Image {
-	return self.frames[self.frameIndex]
-}
-func (self *Animation) InPreLoopPhase() bool {
-	return self.frameIndex < self.loopIndex
-}
-func (self *Animation) Restart() {
-	self.frameIndex = 0
-	self.frameDurationLeft = self.frameDurations[0]
-}
-
-var IdleAnimation Animation
-var MoveAnimation Animation
-
-// --- player ---
-
-type Player struct {
-	x, y float64
-	animation *Animation
-	direction int // -1 = left, 1 = right
-	moving bool
-}
-
-func (self *Player) Update() {
-	for range mipix.Tick().GetRate() {
-		self.animation.Update()
-		self.updateDirection()
-		if self.moving {
-			self.ensureAnimation(&MoveAnimation)
-			preX := self.x
-			switch self.animation.InPreLoopPhase() {
-			case true  : self.x += float64(self.direction)*0.48
-			case false : self.x += float64(self.direction)*1.24
-			}
-	
-			self.x = min(max(self.x, 29.5), 284.5 - PlayerFrameWidth)
-			if self.x == preX { self.moving = false }
-		}
-		if !self.moving {
-			self.ensureAnimation(&IdleAnimation)
-		}
-	}
-}
-
-func (self *Player) DrawHiRes(target *ebiten.Image) {
-	frame := self.animation.GetFrame()
-	if self.direction == -1 {
-		mipix.HiRes().DrawHorzFlip(target, frame, self.x, self.y)
-	} else {
-		mipix.HiRes().Draw(target, frame, self.x, self.y)
-	}
-}
-
-func (self *Player) GetCameraCoords() (float64, float64) {
-	return self.x + PlayerFrameWidth/2.0, self.y + PlayerFrameHeight/4.0
+	return Graphic{ Name: name, X: x, Y: y, Source: source }, nil
 }
 
-func (self *Player) updateDirection() {
-	self.moving = true
-	switch {
-	case ebiten.IsKeyPressed(ebiten.KeyArrowLeft)  : self.direction = -1
-	case ebiten.IsKeyPressed(ebiten.KeyA)          : self.direction = -1
-	case ebiten.IsKeyPressed(ebiten.KeyArrowRight) : self.direction =  1
-	case ebiten.IsKeyPressed(ebiten.KeyD)          : self.direction =  1
-	default: self.moving = false
+func loadImage(filesys fs.FS, path string) (*ebiten.Image, error) {
+	file, err := filesys.Open(path)
+	if err != nil { return nil, err }
+	img, err := png.Decode(file)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
 	}
-}
-
-func (self *Player) ensureAnimation(anim *Animation) {
-	if self.animation == anim { return }
-	self.animation = anim
-	self.animation.Restart()
-}
-
-const PlayerFrameWidth  = 17
-const PlayerFrameHeight = 51
-func getPlayerFrameAt(spritesheet *ebiten.Image, row, col int) *ebiten.Image {
-	rect := image.Rect(
-		PlayerFrameWidth*col, PlayerFrameHeight*row,
-		PlayerFrameWidth*(col + 1), PlayerFrameHeight*(row + 1),
-	)
-	return spritesheet.SubImage(rect).(*ebiten.Image)
+	err = file.Close()
+	if err != nil { return nil, err }
+	return ebiten.NewImageFromImage(img), nil
 }
 
 // --- game ---
 
 type Game struct {
-	backGraphics  []Graphic
-	frontGraphics []Graphic
-	player Player
+	rooms *Rooms
+	room string // current room name
+	level *Level // current room level
+	transition *RoomTransition
+	tiles *LevelTiles
+	tilesDrawn int // since the last debug info draw
+	world *World
+	entities *Entities
+	enemies *Enemies
+	particles *Particles
+	lighting *Lighting
+	player *Player
+	camera *CameraFollower
+	editor *Editor
+	input *Input
+	ui *UI
+	replay *InputReplay
+	statePath string // for save states
+	stateMessage string
 }
 
 func (self *Game) Update() error {
-	if inpututil.IsKeyJustPressed(ebiten.KeyF) {
+	self.input.Update()
+	self.replay.ApplyTo(self.input)
+	if self.input.JustPressed(ActionFullscreen) {
 		ebiten.SetFullscreen(!ebiten.IsFullscreen())
 	}
 	
 	// scaling filter changes
-	if inpututil.IsKeyJustPressed(ebiten.KeyE) {
+	if self.input.JustPressed(ActionFilterNext) {
 		mipix.Scaling().SetFilter((mipix.Scaling().GetFilter() + 1) % 9)
-	} else if inpututil.IsKeyJustPressed(ebiten.KeyQ) {
+	} else if self.input.JustPressed(ActionFilterPrev) {
 		mipix.Scaling().SetFilter((mipix.Scaling().GetFilter() + 8) % 9)
 	}
 
 	// update zoom
-	if inpututil.IsKeyJustPressed(ebiten.KeyZ) {
+	if self.input.JustPressed(ActionZoom) {
 		_, targetZoom := mipix.Camera().GetZoom()
 		if targetZoom == 1.0 {
 			mipix.Camera().Zoom(1.5)
@@ -176,36 +110,252 @@ func (self *Game) Update() error {
 		}
 	}
 
+	// editor mode toggle
+	if self.input.JustPressed(ActionEditor) {
+		x, y := self.player.GetCameraCoords()
+		self.editor.Toggle(x, y)
+		self.ui.Close()
+		self.replay.Stop()
+		if !self.editor.IsActive() {
+			self.tiles = NewLevelTiles(self.level)
+			self.world = NewWorld(self.level)
+			self.entities = NewEntities(self.level)
+			self.enemies = NewEnemies(self.level)
+			self.player.SetItem(nil)
+			self.camera.SetBounds(self.level.Bounds())
+			self.ui.SetTriggers(self.level)
+			self.lighting.Reset(self.level)
+		}
+	}
+	if self.editor.IsActive() {
+		self.editor.Update(self.level)
+		x, y := self.editor.GetCameraCoords()
+		mipix.Camera().NotifyCoordinates(x, y)
+		mipix.Redraw().Request()
+		return nil
+	}
+
+	// input recording and replay
+	if self.replay.Update(self.input) {
+		self.Restart()
+		mipix.Redraw().Request()
+		return nil
+	}
+
+	// save states
+	if self.input.JustPressed(ActionSaveState) {
+		err := NewSave(self.room, self.player, self.camera).Write(self.statePath)
+		if err != nil {
+			self.stateMessage = "Save failed: " + err.Error()
+		} else {
+			self.stateMessage = "Saved state to " + self.statePath
+		}
+	} else if self.input.JustPressed(ActionLoadState) && self.replay.GetMode() != InputReplayOff {
+		self.stateMessage = "Can't load states while recording or replaying"
+	} else if self.input.JustPressed(ActionLoadState) {
+		self.stateMessage = "Loaded state from " + self.statePath
+		err := self.loadState()
+		if err != nil { self.stateMessage = "Load failed: " + err.Error() }
+	}
+
 	// trigger shake
-	if inpututil.IsKeyJustPressed(ebiten.KeyS) {
+	if self.input.JustPressed(ActionShake) {
 		mipix.Camera().TriggerShake(0, 120, 60)
 	}
 
-	// update player and camera
-	self.player.Update()
+	// switch particles between logical and high resolution
+	if self.input.JustPressed(ActionParticleMode) {
+		self.particles.SetHiRes(!self.particles.IsHiRes())
+	}
+
+	// cycle lighting between off, logical and high resolution
+	if self.input.JustPressed(ActionLightingMode) {
+		self.lighting.NextMode()
+	}
+
+	// update dialogues, player and camera. Gameplay is paused
+	// while dialogues are open, including the update where the
+	// dialogue is closed, so the confirm press isn't reused, and
+	// also during room transitions
+	wasBlocking := self.ui.IsBlocking()
+	self.ui.Update(self.player, self.input)
+	if !wasBlocking && !self.ui.IsBlocking() {
+		if !self.transition.IsActive() {
+			self.entities.HandleInput(self.player, self.input)
+		}
+		for range mipix.Tick().GetRate() {
+			if self.transition.IsActive() {
+				self.transition.Update()
+				continue
+			}
+			self.player.Update(self.world, self.input)
+			self.entities.Update(self.world, self.player)
+			self.enemies.Update(self.world, self.player)
+			self.particles.Update()
+			self.lighting.Update()
+			self.updateExits()
+		}
+	}
 	x, y := self.player.GetCameraCoords()
-	mipix.Camera().NotifyCoordinates(x, y)
+	self.camera.Update(x, y, self.player.GetDirection())
 	mipix.Redraw().Request()
 	return nil
 }
 
+// Goes back to the first room, and resets the level entities,
+// enemies, player, camera and dialogues to their initial state.
+func (self *Game) Restart() {
+	self.transition.Cancel()
+	level := self.rooms.Get(self.rooms.GetStart())
+	self.player = NewPlayer(float64(level.Spawn.X), float64(level.Spawn.Y))
+	self.player.SetRespawnHandler(self.resetCamera)
+	self.player.SetEventHandler(self.onPlayerEvent)
+	self.loadRoom(self.rooms.GetStart(), level)
+}
+
+// Loads the state saved at the state path, moving to the
+// room where it was saved if it's not the current one. On
+// failure, nothing is changed.
+func (self *Game) loadState() error {
+	save, err := LoadSave(os.DirFS(filepath.Dir(self.statePath)), filepath.Base(self.statePath))
+	if err != nil { return err }
+	level, found := self.rooms.Find(save.Room)
+	if !found { return fmt.Errorf("state saved in unknown room '%s'", save.Room) }
+	if save.Room == self.room { return save.Apply(self.player, self.camera, nil) }
+	return save.Apply(self.player, self.camera, func() {
+		self.transition.Cancel()
+		self.loadRoom(save.Room, level)
+	})
+}
+
+// Makes the given room the current one, resetting everything
+// but the player, which must be already placed in the room.
+func (self *Game) loadRoom(name string, level *Level) {
+	self.room, self.level = name, level
+	self.tiles = NewLevelTiles(level)
+	self.world = NewWorld(level)
+	self.entities = NewEntities(level)
+	self.enemies = NewEnemies(level)
+	self.particles.Reset()
+	self.lighting.Reset(level)
+	self.camera.SetBounds(level.Bounds())
+	self.resetCamera()
+	self.ui.Reset(level)
+	self.editor.Reset(self.rooms.GetSavePath(name))
+}
+
+// Starts a room transition if the player went past the left
+// or right edge of the level and there's an exit on that side.
+// The next room is loaded and the camera reset while the screen
+// is fully covered.
+func (self *Game) updateExits() {
+	if self.player.IsDead() { return }
+	x, _ := self.player.GetFootCoords()
+	bounds := self.level.Bounds()
+	side := 0
+	switch {
+	case x < float64(bounds.Min.X) : side = -1
+	case x > float64(bounds.Max.X) : side =  1
+	}
+	if side == 0 { return }
+	exit, found := self.level.GetExit(side)
+	if !found { return }
+	self.transition.Start(exit.Transition, side, func() {
+		name, level, x, y := self.rooms.Enter(self.room, exit)
+		self.player.Teleport(x, y)
+		self.loadRoom(name, level)
+	})
+}
+
+func (self *Game) resetCamera() {
+	self.camera.Reset(self.player.GetCameraCoords())
+}
+
+func (self *Game) onPlayerEvent(event string) {
+	x, y := self.player.GetFootCoords()
+	switch event {
+	case "footstep":
+		self.particles.Emit(&FootstepDust, x, y - 1, self.player.GetDirection())
+	case "land":
+		self.particles.Emit(&LandingDust, x, y - 1, self.player.GetDirection())
+	}
+}
+
 func (self *Game) Draw(canvas *ebiten.Image) {
 	if !mipix.Redraw().Pending() { return }
 
-	mipix.Debug().Drawf("[Q/E] %s filter", mipix.Scaling().GetFilter().String())
-	mipix.Debug().Drawf("[A/D] Move")
-	mipix.Debug().Drawf("[F] Fullscreen")
-	mipix.Debug().Drawf("[Z] Zoom")
-	mipix.Debug().Drawf("[S] Shake")
+	input := self.input
+	mipix.Debug().Drawf("[%s] %s filter", input.KeyLabel(ActionFilterPrev, ActionFilterNext), mipix.Scaling().GetFilter().String())
+	if !self.editor.IsActive() {
+		mipix.Debug().Drawf("[%s] Move", input.KeyLabel(ActionMoveLeft, ActionMoveRight))
+		mipix.Debug().Drawf("[%s] Jump", input.KeyLabel(ActionJump))
+		mipix.Debug().Drawf("[%s] Fullscreen", input.KeyLabel(ActionFullscreen))
+		mipix.Debug().Drawf("[%s] Zoom", input.KeyLabel(ActionZoom))
+		mipix.Debug().Drawf("[%s] Shake", input.KeyLabel(ActionShake))
+		mipix.Debug().Drawf("[%s] Pick up/drop", input.KeyLabel(ActionInteract))
+		if self.particles.IsHiRes() {
+			mipix.Debug().Drawf("[%s] Particles: hi-res", input.KeyLabel(ActionParticleMode))
+		} else {
+			mipix.Debug().Drawf("[%s] Particles: logical", input.KeyLabel(ActionParticleMode))
+		}
+		mipix.Debug().Drawf("[%s] Lighting: %s", input.KeyLabel(ActionLightingMode), self.lighting.GetMode().String())
+		mipix.Debug().Drawf("Room: %s", self.room)
+		mipix.Debug().Drawf("[%s] Editor", input.KeyLabel(ActionEditor))
+		mipix.Debug().Drawf("[%s] Save/load state", input.KeyLabel(ActionSaveState, ActionLoadState))
+		if self.stateMessage != "" {
+			mipix.Debug().Drawf("%s", self.stateMessage)
+		}
+		mipix.Debug().Drawf("[%s] Record/replay input", input.KeyLabel(ActionRecordInput, ActionReplayInput))
+		if message := self.replay.GetMessage(); message != "" {
+			mipix.Debug().Drawf("%s", message)
+		}
+		state, event := self.player.GetAnimationDebugInfo()
+		mipix.Debug().Drawf("Anim: %s (last event: %s)", state, event)
+		mipix.Debug().Drawf("Tiles: %d/%d drawn", self.tilesDrawn, self.tiles.Count())
+	}
+	self.tilesDrawn = 0
 
 	canvas.Fill(color.RGBA{244, 232, 232, 255})
-	self.DrawGraphics(canvas, self.backGraphics)
+	self.drawParallaxLayers(canvas, false)
+	self.drawLayer(canvas, self.level.Back, self.tiles.Back)
+	if self.editor.IsActive() {
+		self.DrawGraphics(canvas, self.level.Entities)
+		self.DrawGraphics(canvas, self.level.Enemies)
+	} else {
+		self.entities.Draw(canvas)
+		mipix.QueueHiResDraw(self.DrawHiResEnemies)
+	}
 	mipix.QueueHiResDraw(self.DrawHiResPlayer)
+	if !self.editor.IsActive() {
+		if self.particles.IsHiRes() {
+			mipix.QueueHiResDraw(self.DrawHiResParticles)
+		} else {
+			mipix.QueueDraw(self.particles.Draw)
+		}
+	}
 	mipix.QueueDraw(self.DrawFrontGraphics)
+	if self.editor.IsActive() {
+		mipix.QueueDraw(self.DrawEditor)
+	} else {
+		switch self.lighting.GetMode() {
+		case LightingLogical : mipix.QueueDraw(self.DrawLighting)
+		case LightingHiRes   : mipix.QueueHiResDraw(self.DrawHiResLighting)
+		}
+		if self.transition.IsActive() {
+			mipix.QueueDraw(self.transition.Draw)
+		}
+		self.ui.Draw(self.player, self.input)
+		mipix.QueueHiResDraw(self.DrawHiResUI)
+	}
 }
 
 func (self *Game) DrawGraphics(canvas *ebiten.Image, graphics []Graphic) {
-	origin := mipix.Camera().Area().Min
+	drawGraphicsAt(canvas, graphics, mipix.Camera().Area().Min)
+}
+
+// Draws the graphics with the given origin mapped to the
+// top-left corner of the canvas.
+func drawGraphicsAt(canvas *ebiten.Image, graphics []Graphic, origin image.Point) {
 	var opts ebiten.DrawImageOptions
 	for _, graphic := range graphics {
 		opts.GeoM.Translate(float64(graphic.X - origin.X), float64(graphic.Y - origin.Y))
@@ -214,12 +364,61 @@ func (self *Game) DrawGraphics(canvas *ebiten.Image, graphics []Graphic) {
 	}
 }
 
+func (self *Game) DrawHiResEnemies(viewport, target *ebiten.Image) {
+	self.enemies.DrawHiRes(target)
+}
+
 func (self *Game) DrawHiResPlayer(viewport, target *ebiten.Image) {
 	self.player.DrawHiRes(target)
 }
 
+func (self *Game) DrawHiResParticles(viewport, target *ebiten.Image) {
+	self.particles.DrawHiRes(target)
+}
+
 func (self *Game) DrawFrontGraphics(canvas *ebiten.Image) {
-	self.DrawGraphics(canvas, self.frontGraphics)
+	self.drawLayer(canvas, self.level.Front, self.tiles.Front)
+	self.drawParallaxLayers(canvas, true)
+}
+
+// Draws the level layer through its tile map, or directly
+// from the graphics while editing, as the tile maps are only
+// rebuilt when leaving the editor.
+func (self *Game) drawLayer(canvas *ebiten.Image, graphics []Graphic, tiles *TileMap) {
+	if self.editor.IsActive() {
+		self.DrawGraphics(canvas, graphics)
+	} else {
+		self.tilesDrawn += tiles.Draw(canvas, mipix.Camera().Area().Min)
+	}
+}
+
+// Draws the parallax layers behind the level (factor <= 1)
+// or in front of it (factor > 1).
+func (self *Game) drawParallaxLayers(canvas *ebiten.Image, front bool) {
+	for i, parallax := range self.level.Parallax {
+		if (parallax.Factor > 1.0) != front { continue }
+		if self.editor.IsActive() {
+			parallax.Draw(canvas)
+		} else {
+			self.tilesDrawn += parallax.DrawTiles(canvas, self.tiles.Parallax[i])
+		}
+	}
+}
+
+func (self *Game) DrawLighting(canvas *ebiten.Image) {
+	self.lighting.Draw(canvas, self.player)
+}
+
+func (self *Game) DrawHiResLighting(viewport, target *ebiten.Image) {
+	self.lighting.DrawHiRes(target, self.player)
+}
+
+func (self *Game) DrawHiResUI(viewport, target *ebiten.Image) {
+	self.ui.DrawHiRes(target)
+}
+
+func (self *Game) DrawEditor(canvas *ebiten.Image) {
+	self.editor.Draw(canvas, self.level)
 }
 
 func main() {
@@ -229,80 +428,64 @@ func main() {
 	ebiten.SetScreenClearedEveryFrame(false)
 	mipix.Redraw().SetManaged(true)
 
-	// create animations
-	pss := loadGraphic("player", 0, 0).Source // player spritesheet
-	idle := getPlayerFrameAt(pss, 0, 0)
-	tightFront1 := getPlayerFrameAt(pss, 0, 1)
-	IdleAnimation.AddFrame(idle, 255)
-	IdleAnimation.AddFrame(tightFront1, 30)
-	IdleAnimation.AddFrame(idle, 30)
-	IdleAnimation.AddFrame(getPlayerFrameAt(pss, 0, 3), 30)
-	IdleAnimation.AddFrame(idle, 30)
-	IdleAnimation.AddFrame(tightFront1, 30)
-	IdleAnimation.AddFrame(idle, 80)
-	
-	t := uint8(8)
-	MoveAnimation.AddFrame(getPlayerFrameAt(pss, 1, 0), 11)
-	MoveAnimation.loopIndex = 1
-	MoveAnimation.AddFrame(getPlayerFrameAt(pss, 2, 0), t)
-	MoveAnimation.AddFrame(getPlayerFrameAt(pss, 2, 1), t)
-	MoveAnimation.AddFrame(getPlayerFrameAt(pss, 2, 2), t)
-	MoveAnimation.AddFrame(getPlayerFrameAt(pss, 2, 3), t)
-
-	// set up everything for the game
-	floorY := GameHeight - 34
-	playerX, playerY := float64(106), float64(floorY - PlayerFrameHeight + 3)
-	player := Player{ x: playerX, y: playerY, animation: &IdleAnimation, direction: 1 }
-	game := &Game{
-		backGraphics: []Graphic{
-			loadGraphic("dark_floor_left_corner", 30, floorY),
-			loadGraphic("dark_floor_side", 30, floorY + 21),
-			loadGraphic("dark_floor_center", 67, floorY),
-			loadGraphic("dark_floor_center", 127, floorY),
-			loadGraphic("dark_floor_center", 187, floorY),
-			loadGraphic("dark_floor_right_corner", 247, floorY),
-			loadGraphic("dark_floor_side", 247, floorY + 21),
-			loadGraphic("platform_flat_horz_small_A", -113, 60),
-			loadGraphic("step_small_A", -45, 53),
-			loadGraphic("step_small_B", -25, 46),
-			loadGraphic("step_small_C", -5, 39),
-			loadGraphic("step_small_A", 15, 32),
-			loadGraphic("step_long_A", 35, 25),
-			loadGraphic("step_small_D", 72, 18),
-			loadGraphic("step_small_C", 92, 11),
-			loadGraphic("step_long_A", 112, 4),
-			loadGraphic("platform_flat_horz_small_A", 183, 26),
-			loadGraphic("step_small_D", 251, 20),
-			loadGraphic("platform_ground_square_small_B", -37, 93),
-			loadGraphic("platform_ground_square_small_A", 310, 88),
-			loadGraphic("large_sword_absorbed", 311, 15),
-			loadGraphic("right_sign", -78, 33),
-			loadGraphic("skeleton_A", 189, 19),
-			loadGraphic("back_axe_A", 224, 83),
-			loadGraphic("sword_D", -17, 65),
-			loadGraphic("back_spear_A", 47, 55),
-			loadGraphic("back_skull_A", -27, 87),
-			loadGraphic("axe_A", 204, 3),
-			loadGraphic("sword_A", 174, 82),
-			loadGraphic("skull_B", 165, 102),
-			loadGraphic("back_skeleton_A", 44, 103),
-			loadGraphic("back_skull_A", 183, 104),
-			loadGraphic("back_sword_B", 200, 69),
-			loadGraphic("back_spear_B", 255, 55),
-			loadGraphic("back_skull_B", 88, 104),
-		},
-		frontGraphics: []Graphic{
-			loadGraphic("sword_B", 74, 69),
-			loadGraphic("spear_A", 214, 55),
-		},
-		player: player,
+	// load player animations
+	animations, err := LoadAsepriteAnimations(assets, "assets/player.json", mipix.Tick().TPS())
+	if err != nil { panic(err) }
+	IdleAnimation  = mustGetAnimation(animations, "idle")
+	MoveAnimation  = mustGetAnimation(animations, "move")
+	AirAnimation   = mustGetAnimation(animations, "air")
+	DeathAnimation = mustGetAnimation(animations, "death")
+	LandAnimation  = mustGetAnimation(animations, "land")
+
+	// load rooms, either from the given file or the embedded default
+	levelPath := flag.String("level", "", "first room level file or Tiled map (.tmx, .tmj, .json), instead of the embedded level. Edited rooms are saved next to it")
+	inputPath := flag.String("input", "", "input bindings file, overriding the defaults for the actions it lists")
+	statePath := flag.String("state", "savegame.json", "save state file for the save/load state actions")
+	replayPath := flag.String("replay", "replay.txt", "input recording file for the record/replay input actions")
+	flag.Parse()
+	var rooms *Rooms
+	if *levelPath == "" {
+		// embedded rooms are saved to the working directory
+		rooms, err = LoadRooms(assets, "assets/levels/main.txt", "")
+	} else {
+		levelDir := filepath.Dir(*levelPath)
+		rooms, err = LoadRooms(os.DirFS(levelDir), filepath.Base(*levelPath), levelDir)
 	}
+	if err != nil { panic(err) }
+	level := rooms.Get(rooms.GetStart())
 
-	// set camera initial position
-	camX, camY := player.GetCameraCoords()
-	mipix.Camera().ResetCoordinates(camX, camY)
+	// load input bindings
+	input := NewInput()
+	if *inputPath != "" {
+		err = input.LoadBindings(os.DirFS(filepath.Dir(*inputPath)), filepath.Base(*inputPath))
+		if err != nil { panic(err) }
+	}
+
+	// load dialogues
+	signDialogue, err := LoadDialogue(assets, "assets/dialogues/sign.txt")
+	if err != nil { panic(err) }
+
+	// set up a seeded shaker, so shakes can be replayed
+	shaker := NewSeededShaker(rand.Uint64())
+	mipix.Camera().SetShaker(shaker)
+
+	// set up everything for the game and place
+	// the player and camera at the level start
+	game := &Game{
+		rooms: rooms,
+		transition: NewRoomTransition(),
+		lighting: NewLighting(level),
+		particles: NewParticles(),
+		camera: NewCameraFollower(level.Bounds()),
+		editor: NewEditor(rooms.GetSavePath(rooms.GetStart())),
+		input: input,
+		ui: NewUI(level, signDialogue),
+		replay: NewInputReplay(*replayPath, shaker),
+		statePath: *statePath,
+	}
+	game.Restart()
 
 	// run the game
-	err := mipix.Run(game)
+	err = mipix.Run(game)
 	if err != nil { panic(err) }
 }
@@ -0,0 +1,90 @@
+package main
+
+import "math"
+import "image"
+
+import "github.com/tinne26/mipix"
+import "github.com/hajimehoshi/ebiten/v2"
+
+// A parallax layer is a group of graphics that scrolls at a
+// different speed than the main level layers.
+//
+// Layers with a factor below 1 are drawn behind the back layer
+// and scroll slower, giving the impression of being farther
+// away. Layers with a factor above 1 are drawn over the front
+// layer. The zoom is not affected by the factor: all layers
+// share the camera area size, so at higher zooms parallax
+// layers show fewer pixels just like the main layers do.
+type ParallaxLayer struct {
+	Name string
+	Factor float64 // 0 is fixed to the screen, 1 moves with the world
+	AnchorX, AnchorY int // camera position where the layer aligns with the world
+	RepeatWidth int // horizontal repeat period, 0 if the layer doesn't repeat
+	Graphics []Graphic
+}
+
+// Returns the top-left corner of the visible area in layer
+// coordinates, for the current camera position. Layers with
+// factor 1 match the camera area exactly, like the level layers.
+func (self *ParallaxLayer) GetOrigin() image.Point {
+	if self.Factor == 1.0 { return mipix.Camera().Area().Min }
+
+	// anchors are camera centers, so get the area corner
+	// at the anchor and scale the distance to it instead
+	minX, minY, maxX, maxY := mipix.Camera().AreaF64()
+	anchorMinX := float64(self.AnchorX) - (maxX - minX)/2.0
+	anchorMinY := float64(self.AnchorY) - (maxY - minY)/2.0
+	ox := math.Round(anchorMinX + (minX - anchorMinX)*self.Factor)
+	oy := math.Round(anchorMinY + (minY - anchorMinY)*self.Factor)
+	return image.Pt(int(ox), int(oy))
+}
+
+func (self *ParallaxLayer) Draw(canvas *ebiten.Image) {
+	origin := self.GetOrigin()
+	if self.RepeatWidth <= 0 {
+		drawGraphicsAt(canvas, self.Graphics, origin)
+		return
+	}
+
+	// draw each graphic as many times as necessary to cover the canvas
+	width := canvas.Bounds().Dx()
+	var opts ebiten.DrawImageOptions
+	for _, graphic := range self.Graphics {
+		minX := graphic.X - origin.X
+		maxX := minX + graphic.Source.Bounds().Dx()
+		first := floorDiv(-maxX, self.RepeatWidth) + 1
+		last  := floorDiv(width - minX, self.RepeatWidth)
+		for i := first; i <= last; i++ {
+			x := minX + i*self.RepeatWidth
+			opts.GeoM.Translate(float64(x), float64(graphic.Y - origin.Y))
+			canvas.DrawImage(graphic.Source, &opts)
+			opts.GeoM.Reset()
+		}
+	}
+}
+
+func floorDiv(a, b int) int {
+	q := a/b
+	if (a % b != 0) && ((a < 0) != (b < 0)) { q -= 1 }
+	return q
+}
+
+// Like [ParallaxLayer.Draw](), but drawing through a tile map
+// created from the layer graphics. Returns the number of tiles
+// drawn, including repeats.
+func (self *ParallaxLayer) DrawTiles(canvas *ebiten.Image, tiles *TileMap) int {
+	origin := self.GetOrigin()
+	if self.RepeatWidth <= 0 { return tiles.Draw(canvas, origin) }
+
+	// draw the map as many times as necessary to cover the canvas
+	bounds := tiles.Bounds()
+	if bounds.Empty() { return 0 }
+	minX, maxX := bounds.Min.X - origin.X, bounds.Max.X - origin.X
+	first := floorDiv(-maxX, self.RepeatWidth) + 1
+	last  := floorDiv(canvas.Bounds().Dx() - minX, self.RepeatWidth)
+	var drawn int
+	for i := first; i <= last; i++ {
+		drawn += tiles.Draw(canvas, origin.Sub(image.Pt(i*self.RepeatWidth, 0)))
+	}
+	return drawn
+}
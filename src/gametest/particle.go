@@ -0,0 +1,166 @@
+package main
+
+import "math"
+import "image"
+import "image/color"
+import "math/rand/v2"
+
+import "github.com/tinne26/mipix"
+import "github.com/tinne26/mipix/utils"
+import "github.com/hajimehoshi/ebiten/v2"
+
+// An emitter describes a burst of particles. Horizontal values
+// are given for a right-facing direction, and mirrored when
+// emitting towards the left.
+type ParticleEmitter struct {
+	Count int
+	Lifetime int // in ticks
+	LifetimeSpread int // random extra lifetime, in ticks
+	SpeedX, SpeedY float64 // initial velocity
+	SpreadX, SpreadY float64 // random velocity variation in each direction
+	OffsetSpread float64 // random horizontal spawn offset in each direction
+	Gravity float64
+	Drag float64 // velocity kept per tick
+
+	// Colors from the start to the end of each particle's
+	// lifetime, interpolated linearly. Premultiplied alpha.
+	Ramp []color.RGBA
+}
+
+// Returns the ramp color for the given lifetime progress,
+// between 0 and 1.
+func (self *ParticleEmitter) ColorAt(t float64) color.RGBA {
+	if len(self.Ramp) == 1 { return self.Ramp[0] }
+	t = min(max(t, 0), 1)*float64(len(self.Ramp) - 1)
+	i := min(int(t), len(self.Ramp) - 2)
+	a, b, t := self.Ramp[i], self.Ramp[i + 1], t - float64(i)
+	lerp := func(x, y uint8) uint8 { return uint8(math.Round(float64(x) + (float64(y) - float64(x))*t)) }
+	return color.RGBA{ lerp(a.R, b.R), lerp(a.G, b.G), lerp(a.B, b.B), lerp(a.A, b.A) }
+}
+
+var FootstepDust = ParticleEmitter{
+	Count: 3,
+	Lifetime: 16, LifetimeSpread: 10,
+	SpeedX: -0.3, SpeedY: -0.3,
+	SpreadX: 0.2, SpreadY: 0.15,
+	OffsetSpread: 1.5,
+	Gravity: 0.015,
+	Drag: 0.94,
+	Ramp: []color.RGBA{ utils.RGB(168, 150, 150), utils.RGBA(150, 138, 138, 160), utils.RGBA(0, 0, 0, 0) },
+}
+
+var LandingDust = ParticleEmitter{
+	Count: 10,
+	Lifetime: 20, LifetimeSpread: 14,
+	SpeedX: 0.0, SpeedY: -0.2,
+	SpreadX: 0.9, SpreadY: 0.15,
+	OffsetSpread: 4.0,
+	Gravity: 0.01,
+	Drag: 0.92,
+	Ramp: []color.RGBA{ utils.RGB(168, 150, 150), utils.RGBA(150, 138, 138, 160), utils.RGBA(0, 0, 0, 0) },
+}
+
+type Particle struct {
+	X, Y float64
+	VX, VY float64
+	age, lifetime int
+	emitter *ParticleEmitter
+}
+
+// A set of single pixel particles, which can be drawn either
+// on the logical canvas or at high resolution, to compare how
+// each option looks under the different scaling filters.
+//
+// Particles use their own random source with a fixed seed,
+// so input replays are reproduced exactly.
+type Particles struct {
+	list []Particle
+	source rand.PCG
+	rng *rand.Rand
+	hiRes bool
+}
+
+func NewParticles() *Particles {
+	particles := &Particles{}
+	particles.rng = rand.New(&particles.source)
+	particles.Reset()
+	return particles
+}
+
+// Removes all live particles and reseeds the random source,
+// so particles behave the same every time a room is entered.
+// The resolution mode is kept.
+func (self *Particles) Reset() {
+	self.list = self.list[ : 0]
+	self.source.Seed(0x5EED, 0xD057)
+}
+
+// Spawns the emitter's particles at the given logical
+// coordinates, facing the given direction (-1 or 1).
+func (self *Particles) Emit(emitter *ParticleEmitter, x, y float64, direction int) {
+	dir := float64(direction)
+	for range emitter.Count {
+		self.list = append(self.list, Particle{
+			X: x + self.spread(emitter.OffsetSpread),
+			Y: y,
+			VX: (emitter.SpeedX + self.spread(emitter.SpreadX))*dir,
+			VY: emitter.SpeedY + self.spread(emitter.SpreadY),
+			lifetime: emitter.Lifetime + self.rng.IntN(emitter.LifetimeSpread + 1),
+			emitter: emitter,
+		})
+	}
+}
+
+// Returns a random value between -amount and +amount.
+func (self *Particles) spread(amount float64) float64 {
+	return (self.rng.Float64()*2.0 - 1.0)*amount
+}
+
+// Advances all particles by one tick, removing expired ones.
+func (self *Particles) Update() {
+	alive := self.list[ : 0]
+	for _, particle := range self.list {
+		particle.age += 1
+		if particle.age >= particle.lifetime { continue }
+		particle.VX *= particle.emitter.Drag
+		particle.VY = particle.VY*particle.emitter.Drag + particle.emitter.Gravity
+		particle.X += particle.VX
+		particle.Y += particle.VY
+		alive = append(alive, particle)
+	}
+	self.list = alive
+}
+
+// Returns whether the particles should be drawn with
+// [Particles.DrawHiRes]() instead of [Particles.Draw]().
+func (self *Particles) IsHiRes() bool {
+	return self.hiRes
+}
+
+func (self *Particles) SetHiRes(hiRes bool) {
+	self.hiRes = hiRes
+}
+
+// Draws the particles on the logical canvas, snapped
+// to the pixel grid.
+func (self *Particles) Draw(canvas *ebiten.Image) {
+	origin := mipix.Camera().Area().Min
+	for _, particle := range self.list {
+		x := int(math.Floor(particle.X)) - origin.X
+		y := int(math.Floor(particle.Y)) - origin.Y
+		utils.FillOverRect(canvas, image.Rect(x, y, x + 1, y + 1), particle.color())
+	}
+}
+
+// Draws the particles at high resolution, at their
+// exact positions.
+func (self *Particles) DrawHiRes(target *ebiten.Image) {
+	for _, particle := range self.list {
+		x, y := particle.X, particle.Y
+		mipix.HiRes().FillOverRect(target, x, y, x + 1, y + 1, particle.color())
+	}
+}
+
+func (self *Particle) color() color.RGBA {
+	return self.emitter.ColorAt(float64(self.age)/float64(self.lifetime))
+}
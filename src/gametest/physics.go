@@ -0,0 +1,187 @@
+package main
+
+import "math"
+import "image"
+import "strings"
+
+// --- solids ---
+
+type SolidKind uint8
+const (
+	SolidFull     SolidKind = iota // blocks movement from all sides
+	SolidPlatform // one-way, only blocks movement from above
+)
+
+type Solid struct {
+	Rect image.Rectangle
+	Kind SolidKind
+}
+
+// Returns the collision shape for the given graphic, if any.
+// Shapes are assigned by graphic name prefix, and they skip
+// the top pixel row so feet overlap the surfaces slightly.
+func getGraphicSolid(graphic Graphic) (Solid, bool) {
+	var kind SolidKind
+	switch {
+	case strings.HasPrefix(graphic.Name, "dark_floor_")      : kind = SolidFull
+	case strings.HasPrefix(graphic.Name, "platform_ground_") : kind = SolidFull
+	case strings.HasPrefix(graphic.Name, "platform_flat_")   : kind = SolidPlatform
+	case strings.HasPrefix(graphic.Name, "step_")            : kind = SolidPlatform
+	default:
+		return Solid{}, false
+	}
+	rect := graphic.Bounds()
+	rect.Min.Y += 1
+	return Solid{ Rect: rect, Kind: kind }, true
+}
+
+// --- hazards ---
+
+// Returns the area that kills the player on contact for the
+// given graphic, if any. Spike tips are excluded, as touching
+// them exactly would feel unfair.
+func getGraphicHazard(graphic Graphic) (image.Rectangle, bool) {
+	if !strings.HasPrefix(graphic.Name, "spikes_") {
+		return image.Rectangle{}, false
+	}
+	return graphic.Bounds().Inset(3), true
+}
+
+// --- world ---
+
+// The world contains the collision shapes, hazards and
+// checkpoints of a level.
+type World struct {
+	solids []Solid
+	hazards []image.Rectangle
+	checkpoints []image.Point
+	bounds image.Rectangle
+}
+
+func NewWorld(level *Level) *World {
+	var world World
+	for _, layer := range [][]Graphic{ level.Back, level.Front } {
+		for _, graphic := range layer {
+			solid, found := getGraphicSolid(graphic)
+			if found {
+				world.solids = append(world.solids, solid)
+				world.bounds = world.bounds.Union(solid.Rect)
+			}
+			hazard, found := getGraphicHazard(graphic)
+			if found {
+				world.hazards = append(world.hazards, hazard)
+			}
+		}
+	}
+	world.checkpoints = append(world.checkpoints, level.Spawn)
+	world.checkpoints = append(world.checkpoints, level.Checkpoints...)
+	return &world
+}
+
+// Returns the union of all solids in the world.
+func (self *World) Bounds() image.Rectangle {
+	return self.bounds
+}
+
+// Returns whether the given area overlaps any solid.
+func (self *World) HasSolidIn(rect image.Rectangle) bool {
+	for _, solid := range self.solids {
+		if solid.Rect.Overlaps(rect) { return true }
+	}
+	return false
+}
+
+// Returns whether the given body overlaps any hazard.
+func (self *World) TouchesHazard(body *Body) bool {
+	for _, hazard := range self.hazards {
+		if body.Overlaps(hazard) { return true }
+	}
+	return false
+}
+
+// Returns the checkpoint whose player-frame-sized trigger
+// area overlaps the given body, if any.
+func (self *World) FindCheckpoint(body *Body) (image.Point, bool) {
+	for _, point := range self.checkpoints {
+		trigger := image.Rect(point.X, point.Y, point.X + PlayerFrameWidth, point.Y + PlayerFrameHeight)
+		if body.Overlaps(trigger) { return point, true }
+	}
+	return image.Point{}, false
+}
+
+// --- body ---
+
+// A body is an axis-aligned box that can move through a [World]
+// and collide with its solids. Coordinates refer to the top-left
+// corner of the box.
+type Body struct {
+	X, Y float64
+	Width, Height float64
+	VX, VY float64
+	OnGround bool
+}
+
+// Moves the body horizontally, stopping at any full solid
+// on the way. Returns whether the movement was blocked.
+func (self *Body) MoveX(world *World, dx float64) bool {
+	if dx == 0 { return false }
+	newX := self.X + dx
+	blocked := false
+	for _, solid := range world.solids {
+		if solid.Kind != SolidFull { continue }
+		if !self.overlapsVert(solid.Rect) { continue }
+		minX, maxX := float64(solid.Rect.Min.X), float64(solid.Rect.Max.X)
+		if dx > 0 && self.X + self.Width <= minX && newX + self.Width > minX {
+			newX, blocked = minX - self.Width, true
+		} else if dx < 0 && self.X >= maxX && newX < maxX {
+			newX, blocked = maxX, true
+		}
+	}
+	self.X = newX
+	if blocked { self.VX = 0 }
+	return blocked
+}
+
+// Moves the body vertically, landing on full solids and
+// platforms when falling and hitting the bottom of full
+// solids when going up. Returns whether the movement was
+// blocked, and updates the OnGround flag.
+func (self *Body) MoveY(world *World, dy float64) bool {
+	self.OnGround = false
+	if dy == 0 { return false }
+	newY := self.Y + dy
+	blocked := false
+	for _, solid := range world.solids {
+		if !self.overlapsHorz(solid.Rect) { continue }
+		minY, maxY := float64(solid.Rect.Min.Y), float64(solid.Rect.Max.Y)
+		if dy > 0 && self.Y + self.Height <= minY && newY + self.Height > minY {
+			newY, blocked = minY - self.Height, true
+			self.OnGround = true
+		} else if dy < 0 && solid.Kind == SolidFull && self.Y >= maxY && newY < maxY {
+			newY, blocked = maxY, true
+		}
+	}
+	self.Y = newY
+	if blocked { self.VY = 0 }
+	return blocked
+}
+
+// Returns the body box, expanded to integer coordinates.
+func (self *Body) Rect() image.Rectangle {
+	return image.Rect(
+		int(math.Floor(self.X)), int(math.Floor(self.Y)),
+		int(math.Ceil(self.X + self.Width)), int(math.Ceil(self.Y + self.Height)),
+	)
+}
+
+func (self *Body) Overlaps(rect image.Rectangle) bool {
+	return self.overlapsHorz(rect) && self.overlapsVert(rect)
+}
+
+func (self *Body) overlapsHorz(rect image.Rectangle) bool {
+	return self.X < float64(rect.Max.X) && self.X + self.Width > float64(rect.Min.X)
+}
+
+func (self *Body) overlapsVert(rect image.Rectangle) bool {
+	return self.Y < float64(rect.Max.Y) && self.Y + self.Height > float64(rect.Min.Y)
+}
@@ -0,0 +1,398 @@
+package main
+
+import "fmt"
+
+import "github.com/tinne26/mipix"
+import "github.com/hajimehoshi/ebiten/v2"
+
+const PlayerFrameWidth  = 17
+const PlayerFrameHeight = 51
+
+// Hitbox within the player frame. Horizontally symmetric,
+// so it stays valid when the frame is flipped.
+const PlayerHitboxOffsetX, PlayerHitboxOffsetY = 3, 5
+const PlayerHitboxWidth, PlayerHitboxHeight = 11, 44
+
+// Point within the player frame where held items are
+// attached, when facing right.
+const PlayerHandOffsetX, PlayerHandOffsetY = 13, 34
+
+// Movement parameters, in pixels and ticks.
+const (
+	PlayerStartSpeed = 0.48
+	PlayerRunSpeed = 1.24
+	PlayerGravity = 0.18
+	PlayerMaxFallSpeed = 4.0
+	PlayerJumpSpeed = 4.0
+	PlayerJumpCutFactor = 0.45 // vertical speed kept when releasing jump early
+	PlayerCoyoteTicks = 6 // ticks after leaving the ground where jumping is still allowed
+	PlayerJumpBufferTicks = 6 // ticks a jump press is remembered before landing
+	PlayerDeathTicks = 100 // duration of the death sequence before respawning
+	PlayerDeathBlinkTicks = 36 // final part of the death sequence where the player blinks
+	PlayerKnockbackSpeedX = 2.0
+	PlayerKnockbackSpeedY = 2.4
+	PlayerKnockbackDrag = 0.92 // horizontal speed kept per tick while knocked back
+	PlayerKnockbackTicks = 20 // ticks without horizontal control after being hit
+	PlayerInvulnerableTicks = 80 // ticks after being hit where the player can't be hit again
+	PlayerLandEventSpeed = 1.5 // min falling speed for landings to emit a "land" event
+)
+
+type Player struct {
+	body Body
+	animation *AnimationMachine
+	lastAnimationEvent string
+	direction int // -1 = left, 1 = right
+	moving bool
+	jumpHeld bool
+	coyoteTicksLeft int
+	jumpBufferTicksLeft int
+	deathTicksLeft int // non-zero while the death sequence is playing
+	knockbackTicksLeft int
+	invulnerableTicksLeft int
+	respawnX, respawnY float64 // last checkpoint
+	respawnHandler func()
+	eventHandler func(event string)
+	item *Entity // held item, if any
+	deaths int
+}
+
+// Creates a player with its frame placed at the given coordinates,
+// which will also be used as the initial respawn point.
+func NewPlayer(x, y float64) *Player {
+	player := &Player{ direction: 1, respawnX: x, respawnY: y }
+	player.body.Width, player.body.Height = PlayerHitboxWidth, PlayerHitboxHeight
+	player.setFrameCoords(x, y)
+	player.initAnimationMachine()
+	return player
+}
+
+func (self *Player) initAnimationMachine() {
+	alive := func() bool { return !self.IsDead() }
+	grounded := func() bool { return self.body.OnGround }
+	airborne := func() bool { return !self.IsDead() && !self.body.OnGround }
+	moving := func() bool { return self.moving }
+	stopped := func() bool { return !self.moving }
+	groundedMoving := func() bool { return self.body.OnGround && self.moving }
+
+	self.animation = NewAnimationMachine()
+	self.animation.AddState("idle", IdleAnimation)
+	self.animation.AddState("move", MoveAnimation)
+	self.animation.AddState("air", AirAnimation)
+	self.animation.AddState("death", DeathAnimation)
+	self.animation.AddTransition(AnimationTransition{ From: "*", To: "death", Condition: self.IsDead })
+	self.animation.AddTransition(AnimationTransition{ From: "*", To: "air", Condition: airborne })
+	self.animation.AddTransition(AnimationTransition{ From: "death", To: "idle", Condition: alive })
+	self.animation.AddTransition(AnimationTransition{ From: "air", To: "move", Condition: groundedMoving })
+	self.animation.AddTransition(AnimationTransition{ From: "air", To: "idle", Condition: grounded, Blend: LandAnimation })
+	self.animation.AddTransition(AnimationTransition{ From: "idle", To: "move", Condition: moving })
+	self.animation.AddTransition(AnimationTransition{ From: "move", To: "idle", Condition: stopped })
+	self.animation.AddEvent("move", 2, "footstep")
+	self.animation.AddEvent("move", 4, "footstep")
+	self.animation.SetEventHandler(self.onAnimationEvent)
+}
+
+// Advances the player by one tick.
+func (self *Player) Update(world *World, input *Input) {
+	self.updateTick(world, input)
+	self.animation.Update()
+}
+
+func (self *Player) updateTick(world *World, input *Input) {
+	if self.deathTicksLeft > 0 {
+		self.deathTicksLeft -= 1
+		if self.deathTicksLeft == 0 { self.Respawn() }
+		return
+	}
+	if self.invulnerableTicksLeft > 0 { self.invulnerableTicksLeft -= 1 }
+	self.updateDirection(input)
+	self.updateJump(input)
+
+	// horizontal movement
+	if self.knockbackTicksLeft > 0 {
+		self.knockbackTicksLeft -= 1
+		self.moving = false
+		self.jumpBufferTicksLeft = 0
+		self.body.MoveX(world, self.body.VX)
+		self.body.VX *= PlayerKnockbackDrag
+	} else if self.moving {
+		speed := PlayerRunSpeed
+		if self.body.OnGround && (self.animation.GetState() != "move" || self.animation.GetAnimation().InPreLoopPhase()) {
+			speed = PlayerStartSpeed
+		}
+		blocked := self.body.MoveX(world, float64(self.direction)*speed)
+		if blocked { self.moving = false }
+	}
+
+	// vertical movement
+	if self.jumpBufferTicksLeft > 0 && self.coyoteTicksLeft > 0 {
+		self.body.VY = -PlayerJumpSpeed
+		self.jumpBufferTicksLeft, self.coyoteTicksLeft = 0, 0
+	}
+	self.body.VY = min(self.body.VY + PlayerGravity, PlayerMaxFallSpeed)
+	fallSpeed, wasOnGround := self.body.VY, self.body.OnGround
+	self.body.MoveY(world, self.body.VY)
+	if self.body.OnGround && !wasOnGround && fallSpeed >= PlayerLandEventSpeed {
+		self.emitEvent("land")
+	}
+	if self.body.OnGround {
+		self.coyoteTicksLeft = PlayerCoyoteTicks
+	} else if self.coyoteTicksLeft > 0 {
+		self.coyoteTicksLeft -= 1
+	}
+
+	// checkpoints and death
+	point, found := world.FindCheckpoint(&self.body)
+	if found {
+		self.respawnX, self.respawnY = float64(point.X), float64(point.Y)
+	}
+	if world.TouchesHazard(&self.body) || self.body.Y > float64(world.Bounds().Max.Y + GameHeight) {
+		self.Kill()
+	}
+}
+
+// Returns the current animation state name and the
+// most recent animation event, for debugging.
+func (self *Player) GetAnimationDebugInfo() (string, string) {
+	return self.animation.GetState(), self.lastAnimationEvent
+}
+
+func (self *Player) onAnimationEvent(event string) {
+	self.lastAnimationEvent = event
+	self.emitEvent(event)
+}
+
+// Sets a function to receive player events. These include the
+// animation frame events, like "footstep", and "land" when the
+// player lands after a fall, typically to spawn effects.
+func (self *Player) SetEventHandler(handler func(event string)) {
+	self.eventHandler = handler
+}
+
+func (self *Player) emitEvent(event string) {
+	if self.eventHandler != nil { self.eventHandler(event) }
+}
+
+// Returns whether the player can be knocked back by enemies.
+func (self *Player) CanBeHit() bool {
+	return !self.IsDead() && self.invulnerableTicksLeft == 0
+}
+
+// Pushes the player away in the given horizontal direction
+// and makes it temporarily invulnerable.
+func (self *Player) Knockback(direction int) {
+	self.body.VX = float64(direction)*PlayerKnockbackSpeedX
+	self.body.VY = -PlayerKnockbackSpeedY
+	self.knockbackTicksLeft = PlayerKnockbackTicks
+	self.invulnerableTicksLeft = PlayerInvulnerableTicks
+	self.coyoteTicksLeft = 0
+	mipix.Camera().TriggerShake(0, 10, 20)
+}
+
+// Starts the death sequence, after which the player
+// will respawn at the last checkpoint.
+func (self *Player) Kill() {
+	if self.IsDead() { return }
+	self.deathTicksLeft = PlayerDeathTicks
+	self.deaths += 1
+	self.moving = false
+	self.body.VX, self.body.VY = 0, 0
+	mipix.Camera().TriggerShake(0, 16, 32)
+}
+
+func (self *Player) GetDeaths() int {
+	return self.deaths
+}
+
+func (self *Player) IsDead() bool {
+	return self.deathTicksLeft > 0
+}
+
+// Sets a function to be called after respawning, typically to
+// reset the camera. If no handler is set, the camera is reset
+// directly to the player camera coordinates.
+func (self *Player) SetRespawnHandler(handler func()) {
+	self.respawnHandler = handler
+}
+
+// Moves the player to the last checkpoint and resets
+// the camera there, without any transition.
+func (self *Player) Respawn() {
+	self.setFrameCoords(self.respawnX, self.respawnY)
+	self.body.VX, self.body.VY = 0, 0
+	self.deathTicksLeft = 0
+	self.knockbackTicksLeft, self.invulnerableTicksLeft = 0, 0
+	self.coyoteTicksLeft, self.jumpBufferTicksLeft = 0, 0
+	self.animation.SetState("idle")
+	if self.respawnHandler != nil {
+		self.respawnHandler()
+	} else {
+		mipix.Camera().ResetCoordinates(self.GetCameraCoords())
+	}
+}
+
+// Moves the player frame to the given coordinates and makes
+// them the new respawn point, keeping the rest of the state.
+// Used when entering a room. The held item is dropped, as it
+// belongs to the previous room.
+func (self *Player) Teleport(x, y float64) {
+	self.setFrameCoords(x, y)
+	self.respawnX, self.respawnY = x, y
+	self.SetItem(nil)
+}
+
+// Player state, used to save and restore it. The held item
+// is not included, as it belongs to the level entities.
+type PlayerSnapshot struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	VX float64 `json:"vx"`
+	VY float64 `json:"vy"`
+	OnGround bool `json:"on_ground"`
+	Direction int `json:"direction"`
+	Moving bool `json:"moving"`
+	JumpHeld bool `json:"jump_held"`
+	CoyoteTicksLeft int `json:"coyote_ticks_left"`
+	JumpBufferTicksLeft int `json:"jump_buffer_ticks_left"`
+	DeathTicksLeft int `json:"death_ticks_left"`
+	KnockbackTicksLeft int `json:"knockback_ticks_left"`
+	InvulnerableTicksLeft int `json:"invulnerable_ticks_left"`
+	RespawnX float64 `json:"respawn_x"`
+	RespawnY float64 `json:"respawn_y"`
+	Deaths int `json:"deaths"`
+	Animation AnimationMachineSnapshot `json:"animation"`
+}
+
+func (self *Player) Snapshot() PlayerSnapshot {
+	return PlayerSnapshot{
+		X: self.body.X, Y: self.body.Y,
+		VX: self.body.VX, VY: self.body.VY,
+		OnGround: self.body.OnGround,
+		Direction: self.direction,
+		Moving: self.moving,
+		JumpHeld: self.jumpHeld,
+		CoyoteTicksLeft: self.coyoteTicksLeft,
+		JumpBufferTicksLeft: self.jumpBufferTicksLeft,
+		DeathTicksLeft: self.deathTicksLeft,
+		KnockbackTicksLeft: self.knockbackTicksLeft,
+		InvulnerableTicksLeft: self.invulnerableTicksLeft,
+		RespawnX: self.respawnX, RespawnY: self.respawnY,
+		Deaths: self.deaths,
+		Animation: self.animation.Snapshot(),
+	}
+}
+
+// Restores the player state from a snapshot. The camera is not
+// reset. On failure, the player is left unchanged.
+func (self *Player) Restore(snapshot PlayerSnapshot) error {
+	if snapshot.Direction != 1 && snapshot.Direction != -1 {
+		return fmt.Errorf("invalid player direction %d", snapshot.Direction)
+	}
+	err := self.animation.Restore(snapshot.Animation)
+	if err != nil { return err }
+	self.body.X, self.body.Y = snapshot.X, snapshot.Y
+	self.body.VX, self.body.VY = snapshot.VX, snapshot.VY
+	self.body.OnGround = snapshot.OnGround
+	self.direction = snapshot.Direction
+	self.moving = snapshot.Moving
+	self.jumpHeld = snapshot.JumpHeld
+	self.coyoteTicksLeft = snapshot.CoyoteTicksLeft
+	self.jumpBufferTicksLeft = snapshot.JumpBufferTicksLeft
+	self.deathTicksLeft = snapshot.DeathTicksLeft
+	self.knockbackTicksLeft = snapshot.KnockbackTicksLeft
+	self.invulnerableTicksLeft = snapshot.InvulnerableTicksLeft
+	self.respawnX, self.respawnY = snapshot.RespawnX, snapshot.RespawnY
+	self.deaths = snapshot.Deaths
+	return nil
+}
+
+func (self *Player) DrawHiRes(target *ebiten.Image) {
+	if self.deathTicksLeft > 0 && self.deathTicksLeft < PlayerDeathBlinkTicks {
+		if (self.deathTicksLeft/4) % 2 == 0 { return }
+	} else if self.invulnerableTicksLeft > 0 && (self.invulnerableTicksLeft/4) % 2 == 0 {
+		return
+	}
+	frame := self.animation.GetFrame()
+	x, y := self.getFrameCoords()
+	if self.direction == -1 {
+		mipix.HiRes().DrawHorzFlip(target, frame, x, y)
+	} else {
+		mipix.HiRes().Draw(target, frame, x, y)
+	}
+
+	// held item, with the bottom end a few pixels below the hand
+	if self.item == nil { return }
+	bounds := self.item.Source.Bounds()
+	handX, handY := self.GetHandCoords()
+	itemX := handX - float64(bounds.Dx())/2.0
+	itemY := handY - float64(bounds.Dy()) + 4
+	if self.direction == -1 {
+		mipix.HiRes().DrawHorzFlip(target, self.item.Source, itemX, itemY)
+	} else {
+		mipix.HiRes().Draw(target, self.item.Source, itemX, itemY)
+	}
+}
+
+func (self *Player) GetItem() *Entity {
+	return self.item
+}
+
+func (self *Player) SetItem(item *Entity) {
+	self.item = item
+}
+
+// Returns the point where held items are attached, which
+// is mirrored when the player is facing left.
+func (self *Player) GetHandCoords() (float64, float64) {
+	x, y := self.getFrameCoords()
+	if self.direction == -1 {
+		return x + PlayerFrameWidth - PlayerHandOffsetX, y + PlayerHandOffsetY
+	}
+	return x + PlayerHandOffsetX, y + PlayerHandOffsetY
+}
+
+// Returns -1 if the player is facing left, 1 otherwise.
+func (self *Player) GetDirection() int {
+	return self.direction
+}
+
+// Returns the bottom center of the player hitbox.
+func (self *Player) GetFootCoords() (float64, float64) {
+	return self.body.X + self.body.Width/2.0, self.body.Y + self.body.Height
+}
+
+func (self *Player) GetCameraCoords() (float64, float64) {
+	x, y := self.getFrameCoords()
+	return x + PlayerFrameWidth/2.0, y + PlayerFrameHeight/4.0
+}
+
+func (self *Player) getFrameCoords() (float64, float64) {
+	return self.body.X - PlayerHitboxOffsetX, self.body.Y - PlayerHitboxOffsetY
+}
+
+func (self *Player) setFrameCoords(x, y float64) {
+	self.body.X, self.body.Y = x + PlayerHitboxOffsetX, y + PlayerHitboxOffsetY
+}
+
+func (self *Player) updateDirection(input *Input) {
+	self.moving = true
+	switch {
+	case input.Pressed(ActionMoveLeft)  : self.direction = -1
+	case input.Pressed(ActionMoveRight) : self.direction =  1
+	default: self.moving = false
+	}
+}
+
+// Updates the jump buffer and cuts the jump short if the
+// jump action is released while still going up. Presses are
+// detected per tick instead of per update, so this works
+// the same at any simulation rate.
+func (self *Player) updateJump(input *Input) {
+	held := input.Pressed(ActionJump)
+	if self.jumpBufferTicksLeft > 0 { self.jumpBufferTicksLeft -= 1 }
+	if held && !self.jumpHeld {
+		self.jumpBufferTicksLeft = PlayerJumpBufferTicks
+	} else if !held && self.jumpHeld && self.body.VY < 0 {
+		self.body.VY *= PlayerJumpCutFactor
+	}
+	self.jumpHeld = held
+}
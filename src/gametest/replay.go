@@ -0,0 +1,325 @@
+package main
+
+import "io"
+import "os"
+import "fmt"
+import "bufio"
+import "strconv"
+import "strings"
+import "io/fs"
+import "path/filepath"
+import "math/rand/v2"
+
+import "github.com/tinne26/mipix"
+
+// Current version of the input recording format. Files
+// with other versions are rejected on load.
+const InputRecordingVersion = 1
+
+const scalingFilterCount = 9 // number of mipix.ScalingFilter values
+
+// Returns whether the action is stored on input recordings.
+// Actions that control the editor, save states or recordings
+// themselves are left out, as they depend on state that is
+// not part of the recording.
+func isRecordedAction(action Action) bool {
+	switch action {
+	case ActionEditor, ActionSaveState, ActionLoadState, ActionRecordInput, ActionReplayInput:
+		return false
+	default:
+		return true
+	}
+}
+
+func recordedActions() InputState {
+	var state InputState
+	for action := range actionCount {
+		if isRecordedAction(action) { state = state.With(action) }
+	}
+	return state
+}
+
+// An input recording stores the actions pressed on each update,
+// together with the settings that affect the simulation, so a
+// session can be replayed exactly from the level start.
+//
+// Recordings are plain text files, one command per line:
+//   # comments start with a hash
+//   version <version>
+//   rate <tick rate, from 1 to 256>
+//   filter <scaling filter index>
+//   seed <shake seed>
+//   start <actions>
+//   <updates> <actions>
+//
+// Actions are written as in [Action.String](), separated by
+// spaces, or "-" if none are pressed. The "start" line holds the
+// actions pressed right before the first update, and each of the
+// following numbered lines holds the actions pressed on that
+// number of consecutive updates.
+type InputRecording struct {
+	Rate int
+	Filter mipix.ScalingFilter
+	Seed uint64 // for [SeededShaker]
+	Start InputState
+	Updates []InputState
+}
+
+func LoadInputRecording(filesys fs.FS, path string) (*InputRecording, error) {
+	file, err := filesys.Open(path)
+	if err != nil { return nil, err }
+	recording, err := ParseInputRecording(file, path)
+	closeErr := file.Close()
+	if err != nil { return nil, err }
+	return recording, closeErr
+}
+
+// Parses an input recording from the given reader. The name
+// is only used to give context to error messages.
+func ParseInputRecording(reader io.Reader, name string) (*InputRecording, error) {
+	recording := &InputRecording{ Rate: 1 }
+	hasVersion := false
+	scanner := bufio.NewScanner(reader)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum += 1
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i != -1 { line = line[ : i] }
+		fields := strings.Fields(line)
+		if len(fields) == 0 { continue }
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: missing arguments for '%s'", name, lineNum, fields[0])
+		}
+		if !hasVersion && fields[0] != "version" {
+			return nil, fmt.Errorf("%s:%d: expected 'version <version>' before '%s'", name, lineNum, fields[0])
+		}
+
+		var err error
+		switch fields[0] {
+		case "version":
+			version, err := strconv.Atoi(fields[1])
+			if err != nil || version != InputRecordingVersion {
+				return nil, fmt.Errorf("%s:%d: unsupported version '%s' (expected %d)", name, lineNum, fields[1], InputRecordingVersion)
+			}
+			hasVersion = true
+		case "rate":
+			recording.Rate, err = strconv.Atoi(fields[1])
+			if err != nil || recording.Rate < 1 || recording.Rate > 256 {
+				return nil, fmt.Errorf("%s:%d: invalid tick rate '%s'", name, lineNum, fields[1])
+			}
+		case "filter":
+			filter, err := strconv.Atoi(fields[1])
+			if err != nil || filter < 0 || filter >= scalingFilterCount {
+				return nil, fmt.Errorf("%s:%d: invalid scaling filter '%s'", name, lineNum, fields[1])
+			}
+			recording.Filter = mipix.ScalingFilter(filter)
+		case "seed":
+			recording.Seed, err = strconv.ParseUint(fields[1], 10, 64)
+			if err != nil { return nil, fmt.Errorf("%s:%d: invalid seed '%s'", name, lineNum, fields[1]) }
+		case "start":
+			recording.Start, err = parseRecordedActions(fields[1 : ])
+			if err != nil { return nil, fmt.Errorf("%s:%d: %w", name, lineNum, err) }
+		default:
+			count, err := strconv.Atoi(fields[0])
+			if err != nil { return nil, fmt.Errorf("%s:%d: unknown command '%s'", name, lineNum, fields[0]) }
+			if count <= 0 { return nil, fmt.Errorf("%s:%d: invalid update count %d", name, lineNum, count) }
+			state, err := parseRecordedActions(fields[1 : ])
+			if err != nil { return nil, fmt.Errorf("%s:%d: %w", name, lineNum, err) }
+			for range count {
+				recording.Updates = append(recording.Updates, state)
+			}
+		}
+	}
+	err := scanner.Err()
+	if err != nil { return nil, err }
+	if !hasVersion { return nil, fmt.Errorf("%s: missing version", name) }
+	return recording, nil
+}
+
+func parseRecordedActions(names []string) (InputState, error) {
+	var state InputState
+	if len(names) == 1 && names[0] == "-" { return state, nil }
+	for _, name := range names {
+		action, found := parseAction(name)
+		if !found { return state, fmt.Errorf("unknown action '%s'", name) }
+		if !isRecordedAction(action) { return state, fmt.Errorf("action '%s' can't be recorded", name) }
+		state = state.With(action)
+	}
+	return state, nil
+}
+
+// Writes the recording in the same text format read
+// by [ParseInputRecording].
+func (self *InputRecording) Write(writer io.Writer) error {
+	_, err := fmt.Fprintf(writer, "version %d\nrate %d\n", InputRecordingVersion, self.Rate)
+	if err != nil { return err }
+	_, err = fmt.Fprintf(writer, "filter %d # %s\n", self.Filter, self.Filter.String())
+	if err != nil { return err }
+	_, err = fmt.Fprintf(writer, "seed %d\nstart %s\n", self.Seed, formatRecordedActions(self.Start))
+	if err != nil { return err }
+	for i := 0; i < len(self.Updates); {
+		count := 1
+		for i + count < len(self.Updates) && self.Updates[i + count] == self.Updates[i] { count += 1 }
+		_, err = fmt.Fprintf(writer, "%d %s\n", count, formatRecordedActions(self.Updates[i]))
+		if err != nil { return err }
+		i += count
+	}
+	return nil
+}
+
+func formatRecordedActions(state InputState) string {
+	var names []string
+	for action := range actionCount {
+		if state.Has(action) { names = append(names, action.String()) }
+	}
+	if len(names) == 0 { return "-" }
+	return strings.Join(names, " ")
+}
+
+// Saves the recording to the given file path.
+func (self *InputRecording) Save(path string) error {
+	file, err := os.Create(path)
+	if err != nil { return err }
+	err = self.Write(file)
+	closeErr := file.Close()
+	if err != nil { return err }
+	return closeErr
+}
+
+// --- recorder and player ---
+
+type InputReplayMode uint8
+const (
+	InputReplayOff InputReplayMode = iota
+	InputReplayRecordPending // waiting for the camera to settle before recording
+	InputReplayRecording
+	InputReplayPlayPending // waiting for the camera to settle before replaying
+	InputReplayPlaying
+)
+
+// The input replay records input to a file and plays it back.
+// Both recordings and replays start from the level start, with
+// no zoom nor shakes, and the shaker reseeded, so every update
+// happens exactly like in the recorded session.
+type InputReplay struct {
+	mode InputReplayMode
+	path string
+	shaker *SeededShaker
+	recording *InputRecording
+	index int // next update to replay
+	message string
+	prevRate int // tick rate before replaying, restored on stop
+	prevFilter mipix.ScalingFilter // scaling filter before replaying, restored on stop
+}
+
+// Creates an input replay that saves and loads recordings
+// at the given path, reseeding the given shaker on start.
+func NewInputReplay(path string, shaker *SeededShaker) *InputReplay {
+	return &InputReplay{ path: path, shaker: shaker }
+}
+
+func (self *InputReplay) GetMode() InputReplayMode {
+	return self.mode
+}
+
+// Returns the last status or error message, for debugging.
+func (self *InputReplay) GetMessage() string {
+	return self.message
+}
+
+// Overrides the input with the recorded actions for the current
+// update while replaying. This must be called right after
+// [Input.Update](), before querying any actions.
+func (self *InputReplay) ApplyTo(input *Input) {
+	if self.mode != InputReplayPlaying { return }
+	if self.index >= len(self.recording.Updates) {
+		self.Stop()
+		return
+	}
+	input.ReplaceState(self.recording.Updates[self.index], recordedActions())
+	self.index += 1
+}
+
+// Handles the record and replay actions, and records the input
+// of the current update while recording. Returns true when a
+// recording or replay starts, in which case the game must be
+// restarted and the rest of the update skipped.
+func (self *InputReplay) Update(input *Input) bool {
+	switch {
+	case input.JustPressed(ActionRecordInput):
+		if self.mode == InputReplayOff {
+			self.mode, self.message = InputReplayRecordPending, "Recording soon..."
+		} else {
+			self.Stop()
+		}
+	case input.JustPressed(ActionReplayInput):
+		if self.mode != InputReplayOff {
+			self.Stop()
+			break
+		}
+		recording, err := LoadInputRecording(os.DirFS(filepath.Dir(self.path)), filepath.Base(self.path))
+		if err != nil {
+			self.message = "Replay failed: " + err.Error()
+			break
+		}
+		self.recording = recording
+		self.mode, self.message = InputReplayPlayPending, "Replaying soon..."
+	}
+
+	switch self.mode {
+	case InputReplayRecordPending, InputReplayPlayPending:
+		if !self.settleCamera() { return false }
+		if self.mode == InputReplayRecordPending {
+			self.recording = &InputRecording{
+				Rate: mipix.Tick().GetRate(),
+				Filter: mipix.Scaling().GetFilter(),
+				Seed: rand.Uint64(),
+				Start: input.State() & recordedActions(),
+			}
+			self.mode, self.message = InputReplayRecording, "Recording to " + self.path
+		} else {
+			self.prevRate, self.prevFilter = mipix.Tick().GetRate(), mipix.Scaling().GetFilter()
+			mipix.Tick().SetRate(self.recording.Rate)
+			mipix.Scaling().SetFilter(self.recording.Filter)
+			input.ReplaceState(self.recording.Start, recordedActions())
+			self.index = 0
+			self.mode, self.message = InputReplayPlaying, "Replaying " + self.path
+		}
+		self.shaker.Reseed(self.recording.Seed)
+		return true
+	case InputReplayRecording:
+		self.recording.Updates = append(self.recording.Updates, input.State() & recordedActions())
+	}
+	return false
+}
+
+// Resets the zoom and stops any shakes. Returns true once
+// the camera has settled.
+func (self *InputReplay) settleCamera() bool {
+	mipix.Camera().Zoom(1.0)
+	if mipix.Camera().IsShaking() { mipix.Camera().EndShake(0) }
+	current, _ := mipix.Camera().GetZoom()
+	return current == 1.0 && !mipix.Camera().IsShaking()
+}
+
+// Stops any recording or replay in progress. Recordings
+// are saved when stopped, and replays restore the tick rate
+// and scaling filter that were active before them.
+func (self *InputReplay) Stop() {
+	switch self.mode {
+	case InputReplayRecording:
+		err := self.recording.Save(self.path)
+		if err != nil {
+			self.message = "Recording failed: " + err.Error()
+		} else {
+			self.message = fmt.Sprintf("Recorded %d updates to %s", len(self.recording.Updates), self.path)
+		}
+	case InputReplayPlaying:
+		self.message = fmt.Sprintf("Replayed %d/%d updates", self.index, len(self.recording.Updates))
+		mipix.Tick().SetRate(self.prevRate)
+		mipix.Scaling().SetFilter(self.prevFilter)
+	default:
+		self.message = ""
+	}
+	self.mode = InputReplayOff
+}
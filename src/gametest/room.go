@@ -0,0 +1,116 @@
+package main
+
+import "fmt"
+import "path"
+import "strings"
+import "io/fs"
+import "path/filepath"
+
+// Horizontal distance from the level edge to the player
+// frame when entering a room, in pixels.
+const RoomEntryMargin = 8
+
+// Rooms are levels connected through their exits. All rooms
+// reachable from the first one are loaded upfront, so broken
+// exits are reported on startup instead of mid-game, and they
+// are kept loaded so editor changes persist while moving
+// between rooms.
+//
+// Room names are paths relative to the directory of the
+// first room, on the filesystem the rooms are loaded from.
+// Exit paths are resolved from the room declaring them.
+type Rooms struct {
+	levels map[string]*Level
+	start string
+	saveDir string // empty for the working directory
+}
+
+// Loads the room at the given path and all the rooms reachable
+// from it. If the save directory is empty, edited rooms are
+// saved to the working directory instead of next to the source
+// rooms, which is required for embedded levels.
+func LoadRooms(filesys fs.FS, startPath string, saveDir string) (*Rooms, error) {
+	dir, start := path.Split(startPath)
+	rooms := &Rooms{ levels: make(map[string]*Level), start: start, saveDir: saveDir }
+	pending := []string{ start }
+	for len(pending) > 0 {
+		name := pending[len(pending) - 1]
+		pending = pending[ : len(pending) - 1]
+		if rooms.levels[name] != nil { continue }
+		level, err := loadRoomLevel(filesys, path.Join(dir, name))
+		if err != nil { return nil, err }
+		rooms.levels[name] = level
+		for _, exit := range level.Exits {
+			pending = append(pending, resolveExit(name, exit))
+		}
+	}
+
+	// check that exits lead somewhere they can be entered from
+	for name, level := range rooms.levels {
+		for _, exit := range level.Exits {
+			target := rooms.levels[resolveExit(name, exit)]
+			if _, found := target.GetExit(-exit.Side); !found {
+				return nil, fmt.Errorf("%s: %s exit leads to '%s', which has no %s exit",
+					name, exit.SideName(), exit.Level, sideName(-exit.Side))
+			}
+		}
+	}
+	return rooms, nil
+}
+
+func loadRoomLevel(filesys fs.FS, roomPath string) (*Level, error) {
+	if IsTiledMapPath(roomPath) {
+		return LoadTiledLevel(filesys, roomPath)
+	}
+	return LoadLevel(filesys, roomPath)
+}
+
+// Returns the name of the room the exit leads to.
+func resolveExit(from string, exit LevelExit) string {
+	return path.Join(path.Dir(from), exit.Level)
+}
+
+// Returns the name of the first room.
+func (self *Rooms) GetStart() string {
+	return self.start
+}
+
+// Returns the level for the given room name, which
+// must be the first room or the target of an exit.
+func (self *Rooms) Get(name string) *Level {
+	level, found := self.Find(name)
+	if !found { panic("room '" + name + "' not loaded") }
+	return level
+}
+
+// Like [Rooms.Get](), but returns false instead of
+// panicking if the room is not loaded.
+func (self *Rooms) Find(name string) (*Level, bool) {
+	level := self.levels[path.Clean(name)]
+	return level, level != nil
+}
+
+// Returns the path where the editor saves the given room.
+// Imported Tiled maps are saved in the regular level format.
+func (self *Rooms) GetSavePath(name string) string {
+	name = filepath.FromSlash(path.Clean(name))
+	if IsTiledMapPath(name) {
+		name = strings.TrimSuffix(name, filepath.Ext(name)) + ".txt"
+	}
+	if self.saveDir == "" { return filepath.Base(name) }
+	return filepath.Join(self.saveDir, name)
+}
+
+// Returns the room and the player frame coordinates reached
+// when going through the given exit of the given room.
+func (self *Rooms) Enter(from string, exit LevelExit) (string, *Level, float64, float64) {
+	name := resolveExit(from, exit)
+	level := self.Get(name)
+	entry, _ := level.GetExit(-exit.Side) // checked on load
+	bounds := level.Bounds()
+	x := float64(bounds.Min.X + RoomEntryMargin)
+	if exit.Side == -1 {
+		x = float64(bounds.Max.X - RoomEntryMargin - PlayerFrameWidth)
+	}
+	return name, level, x, float64(entry.Y)
+}
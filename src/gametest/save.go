@@ -0,0 +1,65 @@
+package main
+
+import "os"
+import "fmt"
+import "io/fs"
+import "encoding/json"
+
+// Current version of the save file format. Files with
+// other versions are rejected on load.
+const SaveVersion = 2
+
+// A save captures the player, its animation and the camera, so
+// visual glitches can be reproduced exactly. Entities, enemies
+// and dialogues are not included. Loading a save moves the
+// player to the room where it was made, resetting that room.
+//
+// Saves are stored as JSON files with a top-level "version"
+// field, see [SaveVersion].
+type Save struct {
+	Version int `json:"version"`
+	Room string `json:"room"`
+	Player PlayerSnapshot `json:"player"`
+	Camera CameraSnapshot `json:"camera"`
+}
+
+func NewSave(room string, player *Player, camera *CameraFollower) *Save {
+	return &Save{
+		Version: SaveVersion,
+		Room: room,
+		Player: player.Snapshot(),
+		Camera: camera.Snapshot(),
+	}
+}
+
+func LoadSave(filesys fs.FS, path string) (*Save, error) {
+	data, err := fs.ReadFile(filesys, path)
+	if err != nil { return nil, err }
+	var save Save
+	err = json.Unmarshal(data, &save)
+	if err != nil { return nil, fmt.Errorf("%s: %w", path, err) }
+	if save.Version != SaveVersion {
+		return nil, fmt.Errorf("%s: unsupported save version %d (expected %d)", path, save.Version, SaveVersion)
+	}
+	return &save, nil
+}
+
+// Writes the save to the given file path.
+func (self *Save) Write(path string) error {
+	data, err := json.MarshalIndent(self, "", "\t")
+	if err != nil { return err }
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// Applies the save to the given player and camera. If not nil,
+// enterRoom is called after restoring the player and before
+// restoring the camera, so the room where the save was made can
+// be loaded. On failure, nothing is changed.
+func (self *Save) Apply(player *Player, camera *CameraFollower, enterRoom func()) error {
+	err := self.Camera.Validate() // the player restore validates before changing anything
+	if err != nil { return err }
+	err = player.Restore(self.Player)
+	if err != nil { return err }
+	if enterRoom != nil { enterRoom() }
+	return camera.Restore(self.Camera)
+}
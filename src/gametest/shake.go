@@ -0,0 +1,71 @@
+package main
+
+import "math/rand/v2"
+
+import "github.com/tinne26/mipix"
+
+// Screen shake parameters.
+const (
+	ShakeTravelTime = 0.03 // seconds between shake points
+	ShakeMotionScale = 0.02 // shake range, relative to the smallest resolution dimension
+)
+
+// A screen shaker similar to mipix's default shaker.Random, but
+// with its own random source, so the same seed always produces
+// the same shakes. This is required for input replays, as the
+// built-in shakers use the global random source.
+type SeededShaker struct {
+	rng *rand.Rand
+	fromX, fromY float64
+	toX, toY float64
+	elapsed float64 // seconds since the last shake point
+}
+
+func NewSeededShaker(seed uint64) *SeededShaker {
+	var shaker SeededShaker
+	shaker.Reseed(seed)
+	return &shaker
+}
+
+// Resets the shaker and its random source with the given seed.
+func (self *SeededShaker) Reseed(seed uint64) {
+	*self = SeededShaker{ rng: rand.New(rand.NewPCG(seed, seed)) }
+	self.rollNewTarget()
+}
+
+// Implements mipix's shaker.Shaker interface.
+func (self *SeededShaker) GetShakeOffsets(level float64) (float64, float64) {
+	if level == 0.0 {
+		self.elapsed = 0.0
+		self.rollNewTarget()
+		self.fromX, self.fromY = 0.0, 0.0
+		return 0.0, 0.0
+	}
+
+	t := quadInOut(self.elapsed/ShakeTravelTime)
+	x := self.fromX + (self.toX - self.fromX)*t
+	y := self.fromY + (self.toY - self.fromY)*t
+	self.elapsed += 1.0/float64(mipix.Tick().UPS())
+	for self.elapsed >= ShakeTravelTime {
+		self.elapsed -= ShakeTravelTime
+		self.rollNewTarget()
+	}
+
+	width, height := mipix.GetResolution()
+	axisRange := float64(min(width, height))*ShakeMotionScale
+	level = level*level*(3.0 - 2.0*level) // cubic smoothstep
+	return x*axisRange*level, y*axisRange*level
+}
+
+func (self *SeededShaker) rollNewTarget() {
+	self.fromX, self.fromY = self.toX, self.toY
+	self.toX = self.rng.Float64() - 0.5
+	self.toY = self.rng.Float64() - 0.5
+}
+
+func quadInOut(t float64) float64 {
+	t = min(max(t, 0), 1)
+	if t < 0.5 { return 2*t*t }
+	t = 2*t - 1
+	return -0.5*(t*(t - 2) - 1)
+}
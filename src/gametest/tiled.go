@@ -0,0 +1,830 @@
+package main
+
+import "io"
+import "fmt"
+import "math"
+import "path"
+import "bytes"
+import "image"
+import "slices"
+import "strconv"
+import "strings"
+import "io/fs"
+import "encoding/xml"
+import "encoding/json"
+import "encoding/base64"
+import "encoding/binary"
+import "compress/gzip"
+import "compress/zlib"
+
+// Tiled global tile id flags. Flipped and rotated tiles
+// are not supported.
+const tiledFlipFlags = 0xF0000000
+
+// Loads a level from a Tiled map, in JSON (.tmj, .json) or
+// XML (.tmx) format. External tilesets (.tsj, .json, .tsx)
+// are loaded from the same filesystem, relative to the map.
+//
+// Only orthogonal, finite maps with image collection tilesets
+// are supported, where each tile is one of the gametest assets.
+// Tile image paths are resolved to asset names by taking the
+// path after the last "assets" folder, or only the file name
+// if there's none, and the images themselves are always loaded
+// from the embedded [assets]. This way, imported levels can be
+// saved in the regular level format.
+//
+// Tiled layers are mapped to level layers as follows:
+//  - The "layer" custom property, if present, selects the level
+//    layer: "back", "front", "entity", "enemy" or a parallax
+//    layer name. Objects can also have this property.
+//  - Layers named like the fixed level layers go to that layer.
+//  - Layers with a parallax factor other than 1 become parallax
+//    layers with the same name and the map parallax origin as
+//    anchor. The "repeat" custom property sets the repeat width,
+//    and repeating image layers use the image width by default.
+//  - Other layers go to "back" up to and including the layer
+//    with the spawn object, and to "front" after it.
+//
+// Objects with the "spawn" or "checkpoint" class (or name, if
+// they have no class) set the spawn point and checkpoints, using
+// the object position as the top-left corner of the player frame.
+// Objects with the "exit" class declare level exits, taking the
+// "side", "level" and optional "transition" custom properties
+// and the object y as the entry height (see [LevelExit]).
+// Tile objects become graphics, and any other objects are ignored.
+// Invisible layers and objects are also ignored. Group layers
+// are flattened, combining their offsets and parallax factors.
+func LoadTiledLevel(filesys fs.FS, mapPath string) (*Level, error) {
+	data, err := fs.ReadFile(filesys, mapPath)
+	if err != nil { return nil, err }
+	parse, found := tiledMapParsers[path.Ext(mapPath)]
+	if !found {
+		return nil, fmt.Errorf("%s: unknown Tiled map extension '%s'", mapPath, path.Ext(mapPath))
+	}
+	tmap, err := parse(data)
+	if err != nil { return nil, fmt.Errorf("%s: %w", mapPath, err) }
+	for i := range tmap.Tilesets {
+		err = tmap.Tilesets[i].loadExternal(filesys, path.Dir(mapPath))
+		if err != nil { return nil, fmt.Errorf("%s: %w", mapPath, err) }
+	}
+	level, err := tmap.buildLevel()
+	if err != nil { return nil, fmt.Errorf("%s: %w", mapPath, err) }
+	return level, nil
+}
+
+// Tiled map parsers by file extension. JSON maps can
+// also be exported with the generic ".json" extension.
+var tiledMapParsers = map[string]func([]byte) (*tiledMap, error){
+	".tmx": parseTMX, ".tmj": parseTMJ, ".json": parseTMJ,
+}
+
+// Returns whether the path has the extension of a Tiled map.
+func IsTiledMapPath(filePath string) bool {
+	_, found := tiledMapParsers[path.Ext(filePath)]
+	return found
+}
+
+// --- common map structures ---
+
+// Format independent representation of the parts of a Tiled
+// map relevant to gametest levels.
+type tiledMap struct {
+	Orientation string
+	Infinite bool
+	TileWidth, TileHeight int
+	ParallaxOriginX, ParallaxOriginY float64
+	Tilesets []tiledTileset
+	Layers []tiledLayer
+}
+
+type tiledTileset struct {
+	FirstGID uint32
+	Source string // external tileset path, relative to the map
+	Image string // single image tilesets, unsupported
+	ObjectAlignment string
+	TileOffset image.Point
+	Tiles map[uint32]string // local tile id to asset name
+}
+
+type tiledLayer struct {
+	Type string // "tilelayer", "objectgroup", "imagelayer" or "group"
+	Name string
+	Visible bool
+	OffsetX, OffsetY float64
+	ParallaxX, ParallaxY float64
+	Properties map[string]string
+	Width, Height int // tile layers
+	Data []uint32 // tile layers, global tile ids
+	Objects []tiledObject // object layers
+	Image string // image layers, asset name
+	RepeatX bool // image layers
+	Layers []tiledLayer // groups
+}
+
+type tiledObject struct {
+	Name, Class string
+	GID uint32 // zero if not a tile object
+	X, Y float64
+	Width, Height float64
+	Rotation float64
+	Visible bool
+	Properties map[string]string
+}
+
+// Returns the object class, or the name if it has no class.
+func (self *tiledObject) Kind() string {
+	if self.Class != "" { return self.Class }
+	return self.Name
+}
+
+// Returns the asset name for a Tiled image path, see [LoadTiledLevel].
+func getTiledAssetName(imagePath string) (string, error) {
+	imagePath = path.Clean(strings.ReplaceAll(imagePath, "\\", "/"))
+	if path.Ext(imagePath) != ".png" {
+		return "", fmt.Errorf("image '%s' is not a png", imagePath)
+	}
+	name := strings.TrimSuffix(imagePath, ".png")
+	if i := strings.LastIndex("/" + name, "/assets/"); i != -1 {
+		return name[i + len("assets/") : ], nil
+	}
+	return path.Base(name), nil
+}
+
+// Loads the tileset data from its external file, if any.
+func (self *tiledTileset) loadExternal(filesys fs.FS, mapDir string) error {
+	if self.Source == "" { return nil }
+	tilesetPath := path.Join(mapDir, self.Source)
+	data, err := fs.ReadFile(filesys, tilesetPath)
+	if err != nil { return err }
+	var tileset *tiledTileset
+	switch path.Ext(tilesetPath) {
+	case ".tsx":
+		tileset, err = parseTSX(data)
+	case ".tsj", ".json":
+		tileset, err = parseTSJ(data)
+	default:
+		return fmt.Errorf("%s: unknown Tiled tileset extension '%s'", tilesetPath, path.Ext(tilesetPath))
+	}
+	if err != nil { return fmt.Errorf("%s: %w", tilesetPath, err) }
+	tileset.FirstGID = self.FirstGID
+	*self = *tileset
+	return nil
+}
+
+// --- level building ---
+
+// Layer with the offsets and parallax factors of its groups
+// already applied, and the level layer it maps to.
+type tiledFlatLayer struct {
+	*tiledLayer
+	target string // level layer name, or empty if mapped by order
+}
+
+func (self *tiledMap) buildLevel() (*Level, error) {
+	if self.Orientation != "orthogonal" {
+		return nil, fmt.Errorf("unsupported map orientation '%s'", self.Orientation)
+	}
+	if self.Infinite { return nil, fmt.Errorf("infinite maps are not supported") }
+	for _, tileset := range self.Tilesets {
+		if tileset.Image != "" {
+			return nil, fmt.Errorf("single image tilesets are not supported, use image collections instead")
+		}
+	}
+	slices.SortFunc(self.Tilesets, func(a, b tiledTileset) int { return int(a.FirstGID) - int(b.FirstGID) })
+
+	// flatten layers and find the spawn layer
+	layers := flattenTiledLayers(nil, self.Layers, tiledLayer{ Visible: true, ParallaxX: 1, ParallaxY: 1 }, "")
+	spawnIndex := len(layers) - 1
+	for i, layer := range layers {
+		if slices.ContainsFunc(layer.Objects, func(object tiledObject) bool { return object.Visible && object.Kind() == "spawn" }) {
+			spawnIndex = i
+			break
+		}
+	}
+
+	// build level layers
+	var level Level
+	hasSpawn := false
+	for i, layer := range layers {
+		target, err := self.getLayerTarget(&level, layer, i <= spawnIndex)
+		if err != nil { return nil, fmt.Errorf("layer '%s': %w", layer.Name, err) }
+
+		switch layer.Type {
+		case "tilelayer":
+			err = self.addTileLayer(&level, layer, target)
+		case "imagelayer":
+			if layer.Image == "" { continue }
+			var graphic Graphic
+			graphic, err = tryLoadGraphic(layer.Image, roundToInt(layer.OffsetX), roundToInt(layer.OffsetY))
+			if err == nil { err = addTiledGraphic(&level, target, graphic) }
+		case "objectgroup":
+			for _, object := range layer.Objects {
+				if !object.Visible { continue }
+				switch object.Kind() {
+				case "spawn":
+					if hasSpawn { return nil, fmt.Errorf("layer '%s': duplicate spawn object", layer.Name) }
+					level.Spawn, hasSpawn = image.Pt(roundToInt(object.X + layer.OffsetX), roundToInt(object.Y + layer.OffsetY)), true
+					continue
+				case "checkpoint":
+					point := image.Pt(roundToInt(object.X + layer.OffsetX), roundToInt(object.Y + layer.OffsetY))
+					level.Checkpoints = append(level.Checkpoints, point)
+					continue
+				case "exit":
+					err = addTiledExit(&level, object, roundToInt(object.Y + layer.OffsetY))
+					if err == nil { continue }
+				}
+				if err != nil { break }
+				if object.GID == 0 { continue }
+				err = self.addTileObject(&level, layer, object, target)
+				if err != nil { break }
+			}
+		}
+		if err != nil { return nil, fmt.Errorf("layer '%s': %w", layer.Name, err) }
+	}
+	if !hasSpawn { return nil, fmt.Errorf("missing spawn object") }
+	return &level, nil
+}
+
+func addTiledExit(level *Level, object tiledObject, y int) error {
+	fields := []string{ "exit", object.Properties["side"], object.Properties["level"], strconv.Itoa(y) }
+	if transition, found := object.Properties["transition"]; found {
+		fields = append(fields, transition)
+	}
+	exit, err := parseExitEntry(fields)
+	if err != nil { return fmt.Errorf("object exit: %w", err) }
+	if _, found := level.GetExit(exit.Side); found {
+		return fmt.Errorf("duplicate %s exit", exit.SideName())
+	}
+	level.Exits = append(level.Exits, exit)
+	return nil
+}
+
+func flattenTiledLayers(flat []tiledFlatLayer, layers []tiledLayer, parent tiledLayer, target string) []tiledFlatLayer {
+	for _, layer := range layers {
+		if !layer.Visible { continue }
+		layer.OffsetX += parent.OffsetX
+		layer.OffsetY += parent.OffsetY
+		layer.ParallaxX *= parent.ParallaxX
+		layer.ParallaxY *= parent.ParallaxY
+		layerTarget := target
+		if value, found := layer.Properties["layer"]; found { layerTarget = value }
+		if layer.Type == "group" {
+			flat = flattenTiledLayers(flat, layer.Layers, layer, layerTarget)
+		} else {
+			flat = append(flat, tiledFlatLayer{ tiledLayer: &layer, target: layerTarget })
+		}
+	}
+	return flat
+}
+
+// Returns the level layer that the Tiled layer maps to, creating
+// it first if it's a new parallax layer.
+func (self *tiledMap) getLayerTarget(level *Level, layer tiledFlatLayer, beforeSpawn bool) (string, error) {
+	target := layer.target
+	if target == "" {
+		switch layer.Name {
+		case "back", "front", "entity", "enemy":
+			target = layer.Name
+		}
+	}
+	repeat, err := getTiledRepeat(layer)
+	if err != nil { return "", err }
+	isFixed := (target == "back" || target == "front" || target == "entity" || target == "enemy")
+
+	// regular layers
+	if layer.ParallaxX == 1 && layer.ParallaxY == 1 {
+		if repeat != 0 { return "", fmt.Errorf("only parallax layers can repeat") }
+		if target != "" {
+			if level.GetLayer(target) == nil { return "", fmt.Errorf("unknown level layer '%s'", target) }
+			return target, nil
+		}
+		if beforeSpawn { return "back", nil }
+		return "front", nil
+	}
+
+	// parallax layers
+	if isFixed { return "", fmt.Errorf("level layer '%s' can't have a parallax factor", target) }
+	if layer.ParallaxX != layer.ParallaxY {
+		return "", fmt.Errorf("different horizontal and vertical parallax factors are not supported")
+	}
+	if target == "" { target = layer.Name }
+	parallax := level.GetParallaxLayer(target)
+	if parallax == nil {
+		if target == "" || strings.ContainsAny(target, " \t") {
+			return "", fmt.Errorf("invalid parallax layer name '%s'", target)
+		}
+		_, err := parseParallaxEntry([]string{ "layer", target, "0" }) // validate name
+		if err != nil { return "", err }
+		parallax = &ParallaxLayer{ Name: target, Factor: layer.ParallaxX }
+		parallax.AnchorX, parallax.AnchorY = roundToInt(self.ParallaxOriginX), roundToInt(self.ParallaxOriginY)
+		parallax.RepeatWidth = repeat
+		level.Parallax = append(level.Parallax, parallax)
+	} else if parallax.Factor != layer.ParallaxX || parallax.RepeatWidth != repeat {
+		return "", fmt.Errorf("parallax layer '%s' redeclared with a different factor or repeat", target)
+	}
+	return target, nil
+}
+
+// Returns the repeat width from the "repeat" property, or the
+// image width for repeating image layers, or zero otherwise.
+func getTiledRepeat(layer tiledFlatLayer) (int, error) {
+	if value, found := layer.Properties["repeat"]; found {
+		width, err := strconv.Atoi(value)
+		if err != nil || width < 0 { return 0, fmt.Errorf("invalid repeat width '%s'", value) }
+		return width, nil
+	}
+	if layer.Type != "imagelayer" || !layer.RepeatX || layer.Image == "" { return 0, nil }
+	graphic, err := tryLoadGraphic(layer.Image, 0, 0)
+	if err != nil { return 0, err }
+	return graphic.Source.Bounds().Dx(), nil
+}
+
+func (self *tiledMap) addTileLayer(level *Level, layer tiledFlatLayer, target string) error {
+	if len(layer.Data) != layer.Width*layer.Height {
+		return fmt.Errorf("expected %d tiles, found %d", layer.Width*layer.Height, len(layer.Data))
+	}
+	for i, gid := range layer.Data {
+		if gid == 0 { continue }
+		name, tileset, err := self.getTile(gid)
+		if err != nil { return err }
+
+		// tiles are aligned to the bottom-left corner of their cell
+		col, row := i % layer.Width, i/layer.Width
+		graphic, err := tryLoadGraphic(name, 0, 0)
+		if err != nil { return err }
+		graphic.X = col*self.TileWidth + roundToInt(layer.OffsetX) + tileset.TileOffset.X
+		graphic.Y = (row + 1)*self.TileHeight - graphic.Source.Bounds().Dy() + roundToInt(layer.OffsetY) + tileset.TileOffset.Y
+		err = addTiledGraphic(level, target, graphic)
+		if err != nil { return err }
+	}
+	return nil
+}
+
+func (self *tiledMap) addTileObject(level *Level, layer tiledFlatLayer, object tiledObject, target string) error {
+	if value, found := object.Properties["layer"]; found {
+		if level.GetLayer(value) == nil { return fmt.Errorf("object %s: unknown level layer '%s'", object.Kind(), value) }
+		target = value
+	}
+	name, tileset, err := self.getTile(object.GID)
+	if err != nil { return err }
+	if tileset.ObjectAlignment != "" && tileset.ObjectAlignment != "unspecified" && tileset.ObjectAlignment != "bottomleft" {
+		return fmt.Errorf("unsupported object alignment '%s'", tileset.ObjectAlignment)
+	}
+	if object.Rotation != 0 { return fmt.Errorf("tile object '%s' is rotated", name) }
+
+	// tile objects are positioned by their bottom-left corner
+	graphic, err := tryLoadGraphic(name, 0, 0)
+	if err != nil { return err }
+	width, height := graphic.Source.Bounds().Dx(), graphic.Source.Bounds().Dy()
+	if (object.Width != 0 && roundToInt(object.Width) != width) || (object.Height != 0 && roundToInt(object.Height) != height) {
+		return fmt.Errorf("tile object '%s' is scaled", name)
+	}
+	graphic.X = roundToInt(object.X + layer.OffsetX) + tileset.TileOffset.X
+	graphic.Y = roundToInt(object.Y + layer.OffsetY) - height + tileset.TileOffset.Y
+	return addTiledGraphic(level, target, graphic)
+}
+
+func addTiledGraphic(level *Level, target string, graphic Graphic) error {
+	err := CheckLayerGraphic(target, graphic)
+	if err != nil { return err }
+	layer := level.GetLayer(target)
+	*layer = append(*layer, graphic)
+	return nil
+}
+
+// Returns the asset name and tileset for the given global tile id.
+func (self *tiledMap) getTile(gid uint32) (string, *tiledTileset, error) {
+	if gid & tiledFlipFlags != 0 {
+		return "", nil, fmt.Errorf("flipped or rotated tiles are not supported")
+	}
+	for i := len(self.Tilesets) - 1; i >= 0; i-- {
+		tileset := &self.Tilesets[i]
+		if gid < tileset.FirstGID { continue }
+		name, found := tileset.Tiles[gid - tileset.FirstGID]
+		if !found { break }
+		return name, tileset, nil
+	}
+	return "", nil, fmt.Errorf("unknown tile id %d", gid)
+}
+
+func roundToInt(value float64) int {
+	return int(math.Round(value))
+}
+
+// --- JSON format ---
+
+type tmjMap struct {
+	Orientation string `json:"orientation"`
+	Infinite bool `json:"infinite"`
+	TileWidth int `json:"tilewidth"`
+	TileHeight int `json:"tileheight"`
+	ParallaxOriginX float64 `json:"parallaxoriginx"`
+	ParallaxOriginY float64 `json:"parallaxoriginy"`
+	Tilesets []tmjTileset `json:"tilesets"`
+	Layers []tmjLayer `json:"layers"`
+}
+
+type tmjTileset struct {
+	FirstGID uint32 `json:"firstgid"`
+	Source string `json:"source"`
+	Image string `json:"image"`
+	ObjectAlignment string `json:"objectalignment"`
+	TileOffset struct { X, Y int } `json:"tileoffset"`
+	Tiles []struct {
+		ID uint32 `json:"id"`
+		Image string `json:"image"`
+	} `json:"tiles"`
+}
+
+type tmjLayer struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+	Visible *bool `json:"visible"`
+	OffsetX float64 `json:"offsetx"`
+	OffsetY float64 `json:"offsety"`
+	ParallaxX *float64 `json:"parallaxx"`
+	ParallaxY *float64 `json:"parallaxy"`
+	Properties []tmjProperty `json:"properties"`
+	Width int `json:"width"`
+	Height int `json:"height"`
+	Encoding string `json:"encoding"`
+	Compression string `json:"compression"`
+	Data json.RawMessage `json:"data"` // array or base64 string
+	Objects []struct {
+		Name string `json:"name"`
+		Type string `json:"type"` // class before Tiled 1.9
+		Class string `json:"class"`
+		GID uint32 `json:"gid"`
+		X float64 `json:"x"`
+		Y float64 `json:"y"`
+		Width float64 `json:"width"`
+		Height float64 `json:"height"`
+		Rotation float64 `json:"rotation"`
+		Visible *bool `json:"visible"`
+		Properties []tmjProperty `json:"properties"`
+	} `json:"objects"`
+	Image string `json:"image"`
+	RepeatX bool `json:"repeatx"`
+	Layers []tmjLayer `json:"layers"`
+}
+
+type tmjProperty struct {
+	Name string `json:"name"`
+	Value any `json:"value"`
+}
+
+func parseTMJ(data []byte) (*tiledMap, error) {
+	var tmj tmjMap
+	err := json.Unmarshal(data, &tmj)
+	if err != nil { return nil, err }
+	tmap := &tiledMap{
+		Orientation: tmj.Orientation,
+		Infinite: tmj.Infinite,
+		TileWidth: tmj.TileWidth,
+		TileHeight: tmj.TileHeight,
+		ParallaxOriginX: tmj.ParallaxOriginX,
+		ParallaxOriginY: tmj.ParallaxOriginY,
+	}
+	for _, tileset := range tmj.Tilesets {
+		converted, err := tileset.convert()
+		if err != nil { return nil, err }
+		tmap.Tilesets = append(tmap.Tilesets, *converted)
+	}
+	tmap.Layers, err = convertTMJLayers(tmj.Layers)
+	if err != nil { return nil, err }
+	return tmap, nil
+}
+
+func parseTSJ(data []byte) (*tiledTileset, error) {
+	var tsj tmjTileset
+	err := json.Unmarshal(data, &tsj)
+	if err != nil { return nil, err }
+	return tsj.convert()
+}
+
+func (self *tmjTileset) convert() (*tiledTileset, error) {
+	tileset := &tiledTileset{
+		FirstGID: self.FirstGID,
+		Source: self.Source,
+		Image: self.Image,
+		ObjectAlignment: self.ObjectAlignment,
+		TileOffset: image.Pt(self.TileOffset.X, self.TileOffset.Y),
+		Tiles: make(map[uint32]string, len(self.Tiles)),
+	}
+	for _, tile := range self.Tiles {
+		if tile.Image == "" { continue }
+		name, err := getTiledAssetName(tile.Image)
+		if err != nil { return nil, err }
+		tileset.Tiles[tile.ID] = name
+	}
+	return tileset, nil
+}
+
+func convertTMJLayers(tmjLayers []tmjLayer) ([]tiledLayer, error) {
+	var layers []tiledLayer
+	for _, tmj := range tmjLayers {
+		layer := tiledLayer{
+			Type: tmj.Type,
+			Name: tmj.Name,
+			Visible: tmj.Visible == nil || *tmj.Visible,
+			OffsetX: tmj.OffsetX, OffsetY: tmj.OffsetY,
+			ParallaxX: 1.0, ParallaxY: 1.0,
+			Properties: convertTMJProperties(tmj.Properties),
+			Width: tmj.Width, Height: tmj.Height,
+			RepeatX: tmj.RepeatX,
+		}
+		if tmj.ParallaxX != nil { layer.ParallaxX = *tmj.ParallaxX }
+		if tmj.ParallaxY != nil { layer.ParallaxY = *tmj.ParallaxY }
+
+		var err error
+		switch tmj.Type {
+		case "tilelayer":
+			if len(tmj.Data) == 0 { return nil, fmt.Errorf("layer '%s': missing data, infinite maps are not supported", tmj.Name) }
+			if tmj.Encoding == "base64" {
+				var text string
+				err = json.Unmarshal(tmj.Data, &text)
+				if err == nil { layer.Data, err = decodeTiledBase64(text, tmj.Compression) }
+			} else {
+				err = json.Unmarshal(tmj.Data, &layer.Data)
+			}
+		case "objectgroup":
+			for _, object := range tmj.Objects {
+				class := object.Class
+				if class == "" { class = object.Type }
+				layer.Objects = append(layer.Objects, tiledObject{
+					Name: object.Name, Class: class,
+					GID: object.GID,
+					X: object.X, Y: object.Y,
+					Width: object.Width, Height: object.Height,
+					Rotation: object.Rotation,
+					Visible: object.Visible == nil || *object.Visible,
+					Properties: convertTMJProperties(object.Properties),
+				})
+			}
+		case "imagelayer":
+			if tmj.Image != "" { layer.Image, err = getTiledAssetName(tmj.Image) }
+		case "group":
+			layer.Layers, err = convertTMJLayers(tmj.Layers)
+		default:
+			return nil, fmt.Errorf("layer '%s': unknown layer type '%s'", tmj.Name, tmj.Type)
+		}
+		if err != nil { return nil, fmt.Errorf("layer '%s': %w", tmj.Name, err) }
+		layers = append(layers, layer)
+	}
+	return layers, nil
+}
+
+func convertTMJProperties(properties []tmjProperty) map[string]string {
+	if len(properties) == 0 { return nil }
+	converted := make(map[string]string, len(properties))
+	for _, property := range properties {
+		converted[property.Name] = fmt.Sprint(property.Value)
+	}
+	return converted
+}
+
+// --- XML format ---
+
+type tmxMap struct {
+	Orientation string `xml:"orientation,attr"`
+	Infinite int `xml:"infinite,attr"`
+	TileWidth int `xml:"tilewidth,attr"`
+	TileHeight int `xml:"tileheight,attr"`
+	ParallaxOriginX float64 `xml:"parallaxoriginx,attr"`
+	ParallaxOriginY float64 `xml:"parallaxoriginy,attr"`
+	Properties []tmxProperty `xml:"properties>property"`
+	Tilesets []tmxTileset `xml:"tileset"`
+	Layers []tmxLayer `xml:",any"` // in document order
+}
+
+type tmxTileset struct {
+	FirstGID uint32 `xml:"firstgid,attr"`
+	Source string `xml:"source,attr"`
+	ObjectAlignment string `xml:"objectalignment,attr"`
+	TileOffset struct {
+		X int `xml:"x,attr"`
+		Y int `xml:"y,attr"`
+	} `xml:"tileoffset"`
+	Image *tmxImage `xml:"image"`
+	Tiles []struct {
+		ID uint32 `xml:"id,attr"`
+		Image *tmxImage `xml:"image"`
+	} `xml:"tile"`
+}
+
+type tmxImage struct {
+	Source string `xml:"source,attr"`
+}
+
+type tmxLayer struct {
+	XMLName xml.Name
+	Name string `xml:"name,attr"`
+	Visible *int `xml:"visible,attr"`
+	OffsetX float64 `xml:"offsetx,attr"`
+	OffsetY float64 `xml:"offsety,attr"`
+	ParallaxX *float64 `xml:"parallaxx,attr"`
+	ParallaxY *float64 `xml:"parallaxy,attr"`
+	RepeatX int `xml:"repeatx,attr"`
+	Width int `xml:"width,attr"`
+	Height int `xml:"height,attr"`
+	Properties []tmxProperty `xml:"properties>property"`
+	Data *struct {
+		Encoding string `xml:"encoding,attr"`
+		Compression string `xml:"compression,attr"`
+		Text string `xml:",chardata"`
+		Tiles []struct {
+			GID uint32 `xml:"gid,attr"`
+		} `xml:"tile"`
+		Chunks []struct{} `xml:"chunk"`
+	} `xml:"data"`
+	Objects []struct {
+		Name string `xml:"name,attr"`
+		Type string `xml:"type,attr"` // class before Tiled 1.9
+		Class string `xml:"class,attr"`
+		GID uint32 `xml:"gid,attr"`
+		X float64 `xml:"x,attr"`
+		Y float64 `xml:"y,attr"`
+		Width float64 `xml:"width,attr"`
+		Height float64 `xml:"height,attr"`
+		Rotation float64 `xml:"rotation,attr"`
+		Visible *int `xml:"visible,attr"`
+		Properties []tmxProperty `xml:"properties>property"`
+	} `xml:"object"`
+	Image *tmxImage `xml:"image"`
+	Layers []tmxLayer `xml:",any"` // groups, in document order
+}
+
+type tmxProperty struct {
+	Name string `xml:"name,attr"`
+	Value *string `xml:"value,attr"`
+	Text string `xml:",chardata"` // multiline strings
+}
+
+func parseTMX(data []byte) (*tiledMap, error) {
+	var tmx tmxMap
+	err := xml.Unmarshal(data, &tmx)
+	if err != nil { return nil, err }
+	tmap := &tiledMap{
+		Orientation: tmx.Orientation,
+		Infinite: tmx.Infinite != 0,
+		TileWidth: tmx.TileWidth,
+		TileHeight: tmx.TileHeight,
+		ParallaxOriginX: tmx.ParallaxOriginX,
+		ParallaxOriginY: tmx.ParallaxOriginY,
+	}
+	for _, tileset := range tmx.Tilesets {
+		converted, err := tileset.convert()
+		if err != nil { return nil, err }
+		tmap.Tilesets = append(tmap.Tilesets, *converted)
+	}
+	tmap.Layers, err = convertTMXLayers(tmx.Layers)
+	if err != nil { return nil, err }
+	return tmap, nil
+}
+
+func parseTSX(data []byte) (*tiledTileset, error) {
+	var tsx tmxTileset
+	err := xml.Unmarshal(data, &tsx)
+	if err != nil { return nil, err }
+	return tsx.convert()
+}
+
+func (self *tmxTileset) convert() (*tiledTileset, error) {
+	tileset := &tiledTileset{
+		FirstGID: self.FirstGID,
+		Source: self.Source,
+		ObjectAlignment: self.ObjectAlignment,
+		TileOffset: image.Pt(self.TileOffset.X, self.TileOffset.Y),
+		Tiles: make(map[uint32]string, len(self.Tiles)),
+	}
+	if self.Image != nil { tileset.Image = self.Image.Source }
+	for _, tile := range self.Tiles {
+		if tile.Image == nil { continue }
+		name, err := getTiledAssetName(tile.Image.Source)
+		if err != nil { return nil, err }
+		tileset.Tiles[tile.ID] = name
+	}
+	return tileset, nil
+}
+
+func convertTMXLayers(tmxLayers []tmxLayer) ([]tiledLayer, error) {
+	var layers []tiledLayer
+	for _, tmx := range tmxLayers {
+		layer := tiledLayer{
+			Name: tmx.Name,
+			Visible: tmx.Visible == nil || *tmx.Visible != 0,
+			OffsetX: tmx.OffsetX, OffsetY: tmx.OffsetY,
+			ParallaxX: 1.0, ParallaxY: 1.0,
+			Properties: convertTMXProperties(tmx.Properties),
+			Width: tmx.Width, Height: tmx.Height,
+			RepeatX: tmx.RepeatX != 0,
+		}
+		if tmx.ParallaxX != nil { layer.ParallaxX = *tmx.ParallaxX }
+		if tmx.ParallaxY != nil { layer.ParallaxY = *tmx.ParallaxY }
+
+		var err error
+		switch tmx.XMLName.Local {
+		case "layer":
+			layer.Type = "tilelayer"
+			layer.Data, err = decodeTMXData(tmx)
+		case "objectgroup":
+			layer.Type = "objectgroup"
+			for _, object := range tmx.Objects {
+				class := object.Class
+				if class == "" { class = object.Type }
+				layer.Objects = append(layer.Objects, tiledObject{
+					Name: object.Name, Class: class,
+					GID: object.GID,
+					X: object.X, Y: object.Y,
+					Width: object.Width, Height: object.Height,
+					Rotation: object.Rotation,
+					Visible: object.Visible == nil || *object.Visible != 0,
+					Properties: convertTMXProperties(object.Properties),
+				})
+			}
+		case "imagelayer":
+			layer.Type = "imagelayer"
+			if tmx.Image != nil && tmx.Image.Source != "" {
+				layer.Image, err = getTiledAssetName(tmx.Image.Source)
+			}
+		case "group":
+			layer.Type = "group"
+			layer.Layers, err = convertTMXLayers(tmx.Layers)
+		default:
+			continue // editor settings and other elements
+		}
+		if err != nil { return nil, fmt.Errorf("layer '%s': %w", tmx.Name, err) }
+		layers = append(layers, layer)
+	}
+	return layers, nil
+}
+
+func decodeTMXData(tmx tmxLayer) ([]uint32, error) {
+	data := tmx.Data
+	if data == nil { return nil, fmt.Errorf("missing data") }
+	if len(data.Chunks) > 0 { return nil, fmt.Errorf("infinite maps are not supported") }
+	switch data.Encoding {
+	case "csv":
+		var gids []uint32
+		for _, field := range strings.Split(data.Text, ",") {
+			field = strings.TrimSpace(field)
+			if field == "" { continue }
+			gid, err := strconv.ParseUint(field, 10, 32)
+			if err != nil { return nil, fmt.Errorf("invalid tile id '%s'", field) }
+			gids = append(gids, uint32(gid))
+		}
+		return gids, nil
+	case "base64":
+		return decodeTiledBase64(data.Text, data.Compression)
+	case "":
+		gids := make([]uint32, 0, len(data.Tiles))
+		for _, tile := range data.Tiles {
+			gids = append(gids, tile.GID)
+		}
+		return gids, nil
+	default:
+		return nil, fmt.Errorf("unknown data encoding '%s'", data.Encoding)
+	}
+}
+
+func convertTMXProperties(properties []tmxProperty) map[string]string {
+	if len(properties) == 0 { return nil }
+	converted := make(map[string]string, len(properties))
+	for _, property := range properties {
+		if property.Value != nil {
+			converted[property.Name] = *property.Value
+		} else {
+			converted[property.Name] = property.Text
+		}
+	}
+	return converted
+}
+
+// Decodes base64 tile data, optionally compressed, into
+// global tile ids.
+func decodeTiledBase64(text string, compression string) ([]uint32, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(text))
+	if err != nil { return nil, err }
+	var reader io.ReadCloser
+	switch compression {
+	case "":
+		reader = io.NopCloser(bytes.NewReader(raw))
+	case "zlib":
+		reader, err = zlib.NewReader(bytes.NewReader(raw))
+	case "gzip":
+		reader, err = gzip.NewReader(bytes.NewReader(raw))
+	default:
+		return nil, fmt.Errorf("unsupported data compression '%s'", compression)
+	}
+	if err != nil { return nil, err }
+	raw, err = io.ReadAll(reader)
+	closeErr := reader.Close()
+	if err != nil { return nil, err }
+	if closeErr != nil { return nil, closeErr }
+	if len(raw) % 4 != 0 { return nil, fmt.Errorf("invalid tile data length %d", len(raw)) }
+	gids := make([]uint32, len(raw)/4)
+	for i := range gids {
+		gids[i] = binary.LittleEndian.Uint32(raw[i*4 : ])
+	}
+	return gids, nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import "math"
+import "image"
+import "slices"
+
+import "github.com/hajimehoshi/ebiten/v2"
+
+// Tile map parameters, in pixels.
+const (
+	TileAtlasWidth = 1024 // min atlas width, wider sources widen the atlas
+	TileAtlasPadding = 1 // empty space around each source in the atlas
+	TileMapCellSize = 64 // size of the spatial grid cells
+)
+
+// Max tiles per DrawTriangles() call, limited by uint16 indices.
+const tileMapBatchSize = math.MaxUint16/4
+
+// A tile atlas packs multiple source images into a single image,
+// so tile maps can draw all their tiles with a single call.
+type TileAtlas struct {
+	image *ebiten.Image
+	rects map[*ebiten.Image]image.Rectangle // source to atlas region
+}
+
+// Packs the given sources into a new atlas, in rows sorted by
+// height. Repeated sources are only packed once.
+func NewTileAtlas(sources []*ebiten.Image) *TileAtlas {
+	sorted := slices.Clone(sources)
+	slices.SortStableFunc(sorted, func(a, b *ebiten.Image) int {
+		return b.Bounds().Dy() - a.Bounds().Dy()
+	})
+
+	// compute the layout
+	width := TileAtlasWidth
+	for _, source := range sorted {
+		width = max(width, source.Bounds().Dx() + TileAtlasPadding*2)
+	}
+	rects := make(map[*ebiten.Image]image.Rectangle, len(sorted))
+	x, y, rowHeight := 0, 0, 0
+	for _, source := range sorted {
+		if _, found := rects[source]; found { continue }
+		w := source.Bounds().Dx() + TileAtlasPadding*2
+		h := source.Bounds().Dy() + TileAtlasPadding*2
+		if x + w > width {
+			x, y, rowHeight = 0, y + rowHeight, 0
+		}
+		rects[source] = image.Rect(x, y, x + w, y + h).Inset(TileAtlasPadding)
+		x += w
+		rowHeight = max(rowHeight, h)
+	}
+
+	// copy the sources
+	atlas := &TileAtlas{ image: ebiten.NewImage(width, max(y + rowHeight, 1)), rects: rects }
+	var opts ebiten.DrawImageOptions
+	for source, rect := range rects {
+		bounds := source.Bounds()
+		opts.GeoM.Translate(float64(rect.Min.X - bounds.Min.X), float64(rect.Min.Y - bounds.Min.Y))
+		atlas.image.DrawImage(source, &opts)
+		opts.GeoM.Reset()
+	}
+	return atlas
+}
+
+// Creates an atlas with the sources of all the graphics in
+// the given layers.
+func NewTileAtlasForGraphics(layers ...[]Graphic) *TileAtlas {
+	var sources []*ebiten.Image
+	for _, graphics := range layers {
+		for _, graphic := range graphics {
+			sources = append(sources, graphic.Source)
+		}
+	}
+	return NewTileAtlas(sources)
+}
+
+// A tile map draws a set of graphics from a [TileAtlas], skipping
+// the ones outside the canvas. Graphics are indexed on a spatial
+// grid, so drawing only has to look at the tiles of the visible
+// cells, no matter how big the map is. Visible tiles are still
+// drawn in their original order.
+//
+// Tile maps don't track changes to the graphics they were
+// created from, they have to be recreated instead.
+type TileMap struct {
+	atlas *TileAtlas
+	tiles []mapTile
+	cells map[image.Point][]int // ascending indices of the tiles overlapping each cell
+	bounds image.Rectangle
+
+	// reused between draws
+	visible []int
+	vertices []ebiten.Vertex
+	indices []uint16
+}
+
+type mapTile struct {
+	src image.Rectangle // atlas region
+	dst image.Rectangle // map region
+}
+
+// Creates a tile map for the given graphics. All the graphic
+// sources must be in the atlas.
+func NewTileMap(atlas *TileAtlas, graphics []Graphic) *TileMap {
+	tileMap := &TileMap{
+		atlas: atlas,
+		tiles: make([]mapTile, 0, len(graphics)),
+		cells: make(map[image.Point][]int, len(graphics)),
+	}
+	for i, graphic := range graphics {
+		src, found := atlas.rects[graphic.Source]
+		if !found { panic("graphic '" + graphic.Name + "' not in atlas") }
+		dst := graphic.Bounds()
+		tileMap.tiles = append(tileMap.tiles, mapTile{ src: src, dst: dst })
+		tileMap.bounds = tileMap.bounds.Union(dst)
+		minCell, maxCell := getTileMapCellRange(dst)
+		for cy := minCell.Y; cy <= maxCell.Y; cy++ {
+			for cx := minCell.X; cx <= maxCell.X; cx++ {
+				cell := image.Pt(cx, cy)
+				tileMap.cells[cell] = append(tileMap.cells[cell], i)
+			}
+		}
+	}
+	return tileMap
+}
+
+// Returns the union of all the tile areas.
+func (self *TileMap) Bounds() image.Rectangle {
+	return self.bounds
+}
+
+// Draws the tiles that overlap the canvas, with the given origin
+// mapped to the top-left corner of the canvas, and returns the
+// number of tiles drawn.
+func (self *TileMap) Draw(canvas *ebiten.Image, origin image.Point) int {
+	area := image.Rectangle{ Min: origin, Max: origin.Add(canvas.Bounds().Size()) }
+	if !area.Overlaps(self.bounds) { return 0 }
+
+	// collect visible tiles from the grid
+	self.visible = self.visible[ : 0]
+	minCell, maxCell := getTileMapCellRange(area.Intersect(self.bounds))
+	for cy := minCell.Y; cy <= maxCell.Y; cy++ {
+		for cx := minCell.X; cx <= maxCell.X; cx++ {
+			for _, index := range self.cells[image.Pt(cx, cy)] {
+				if self.tiles[index].dst.Overlaps(area) {
+					self.visible = append(self.visible, index)
+				}
+			}
+		}
+	}
+	slices.Sort(self.visible)
+	self.visible = slices.Compact(self.visible) // tiles can span multiple cells
+
+	// draw in batches
+	var opts ebiten.DrawTrianglesOptions
+	for start := 0; start < len(self.visible); start += tileMapBatchSize {
+		end := min(start + tileMapBatchSize, len(self.visible))
+		self.vertices, self.indices = self.vertices[ : 0], self.indices[ : 0]
+		for _, index := range self.visible[start : end] {
+			tile := self.tiles[index]
+			self.appendQuad(tile.src, tile.dst.Sub(origin))
+		}
+		canvas.DrawTriangles(self.vertices, self.indices, self.atlas.image, &opts)
+	}
+	return len(self.visible)
+}
+
+func (self *TileMap) appendQuad(src, dst image.Rectangle) {
+	base := uint16(len(self.vertices))
+	corners := [4][2]int{ {0, 0}, {1, 0}, {0, 1}, {1, 1} }
+	for _, corner := range corners {
+		sx, dx := src.Min.X, dst.Min.X
+		if corner[0] == 1 { sx, dx = src.Max.X, dst.Max.X }
+		sy, dy := src.Min.Y, dst.Min.Y
+		if corner[1] == 1 { sy, dy = src.Max.Y, dst.Max.Y }
+		self.vertices = append(self.vertices, ebiten.Vertex{
+			DstX: float32(dx), DstY: float32(dy),
+			SrcX: float32(sx), SrcY: float32(sy),
+			ColorR: 1, ColorG: 1, ColorB: 1, ColorA: 1,
+		})
+	}
+	self.indices = append(self.indices, base, base + 1, base + 2, base + 1, base + 3, base + 2)
+}
+
+// Returns the first and last grid cells overlapped by the
+// given non-empty rectangle.
+func getTileMapCellRange(rect image.Rectangle) (image.Point, image.Point) {
+	minCell := image.Pt(floorDiv(rect.Min.X, TileMapCellSize), floorDiv(rect.Min.Y, TileMapCellSize))
+	maxCell := image.Pt(floorDiv(rect.Max.X - 1, TileMapCellSize), floorDiv(rect.Max.Y - 1, TileMapCellSize))
+	return minCell, maxCell
+}
+
+// Tile maps for the back, front and parallax layers of a
+// level, sharing a single atlas.
+type LevelTiles struct {
+	Back, Front *TileMap
+	Parallax []*TileMap // same order as [Level].Parallax
+}
+
+func NewLevelTiles(level *Level) *LevelTiles {
+	layers := [][]Graphic{ level.Back, level.Front }
+	for _, parallax := range level.Parallax {
+		layers = append(layers, parallax.Graphics)
+	}
+	atlas := NewTileAtlasForGraphics(layers...)
+	tiles := &LevelTiles{
+		Back: NewTileMap(atlas, level.Back),
+		Front: NewTileMap(atlas, level.Front),
+	}
+	for _, parallax := range level.Parallax {
+		tiles.Parallax = append(tiles.Parallax, NewTileMap(atlas, parallax.Graphics))
+	}
+	return tiles
+}
+
+// Returns the total number of tiles in all the maps.
+func (self *LevelTiles) Count() int {
+	count := len(self.Back.tiles) + len(self.Front.tiles)
+	for _, tileMap := range self.Parallax {
+		count += len(tileMap.tiles)
+	}
+	return count
+}
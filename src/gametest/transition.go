@@ -0,0 +1,178 @@
+package main
+
+import "math"
+import "image/color"
+
+import "github.com/tinne26/mipix/utils"
+import "github.com/hajimehoshi/ebiten/v2"
+
+var TransitionRGB = utils.RGB(20, 12, 18)
+
+// Duration of each half of a room transition, in ticks.
+const RoomTransitionTicks = 32
+
+type TransitionKind uint8
+const (
+	TransitionFade     TransitionKind = iota // the whole screen fades to a flat color
+	TransitionDissolve // pixels are covered one by one in a fixed pseudo-random order
+	TransitionWipe     // a flat color sweeps the screen in the movement direction
+)
+
+func (self TransitionKind) String() string {
+	switch self {
+	case TransitionFade     : return "fade"
+	case TransitionDissolve : return "dissolve"
+	case TransitionWipe     : return "wipe"
+	default:
+		panic("invalid TransitionKind")
+	}
+}
+
+func parseTransitionKind(name string) (TransitionKind, bool) {
+	switch name {
+	case "fade"     : return TransitionFade, true
+	case "dissolve" : return TransitionDissolve, true
+	case "wipe"     : return TransitionWipe, true
+	}
+	return 0, false
+}
+
+// A room transition covers the screen, calls a function while
+// it's fully covered, and uncovers it again. The function is
+// where the next room is loaded and the camera is reset with
+// mipix.Camera().ResetCoordinates(), so the jump is never seen.
+//
+// Transitions are drawn on the logical canvas, so they are
+// pixel-perfect at any zoom level, and they are advanced by
+// ticks, so they look the same on input replays.
+type RoomTransition struct {
+	kind TransitionKind
+	direction int // -1 or 1, for wipes
+	ticks int // elapsed ticks, zero when inactive
+	onCovered func()
+	mask *ebiten.Image // dissolve pattern, reused between draws
+	maskPixels []byte
+}
+
+func NewRoomTransition() *RoomTransition {
+	return &RoomTransition{}
+}
+
+// Starts a transition. Wipes move towards the given direction
+// (-1 for left, 1 for right). The given function is called on
+// the update where the screen becomes fully covered.
+func (self *RoomTransition) Start(kind TransitionKind, direction int, onCovered func()) {
+	self.kind, self.direction = kind, direction
+	self.ticks = 1
+	self.onCovered = onCovered
+}
+
+func (self *RoomTransition) IsActive() bool {
+	return self.ticks > 0
+}
+
+// Ends the transition immediately, without calling the
+// pending function if the screen wasn't covered yet.
+func (self *RoomTransition) Cancel() {
+	self.ticks = 0
+	self.onCovered = nil
+}
+
+// Advances the transition by one tick.
+func (self *RoomTransition) Update() {
+	if self.ticks == 0 { return }
+	self.ticks += 1
+	if self.ticks == RoomTransitionTicks && self.onCovered != nil {
+		self.onCovered()
+		self.onCovered = nil
+	}
+	if self.ticks >= RoomTransitionTicks*2 { self.ticks = 0 }
+}
+
+// Returns whether the screen is being uncovered, and the
+// transition progress within the current half, from 0 to 1.
+func (self *RoomTransition) progress() (bool, float64) {
+	if self.ticks <= RoomTransitionTicks {
+		return false, float64(self.ticks)/RoomTransitionTicks
+	}
+	return true, float64(self.ticks - RoomTransitionTicks)/RoomTransitionTicks
+}
+
+// Draws the transition over the logical canvas.
+func (self *RoomTransition) Draw(canvas *ebiten.Image) {
+	if self.ticks == 0 { return }
+	uncovering, t := self.progress()
+	bounds := canvas.Bounds()
+	switch self.kind {
+	case TransitionFade:
+		if uncovering { t = 1.0 - t }
+		utils.FillOverRect(canvas, bounds, scaleAlpha(TransitionRGB, t))
+	case TransitionDissolve:
+		if uncovering { t = 1.0 - t }
+		self.drawDissolve(canvas, t)
+	case TransitionWipe:
+		// the covered area enters from one side and
+		// leaves through the other, as a single sweep
+		width := bounds.Dx()
+		edge := int(math.Round(t*float64(width)))
+		rect := bounds
+		switch {
+		case !uncovering && self.direction == 1 : rect.Max.X = bounds.Min.X + edge
+		case  uncovering && self.direction == 1 : rect.Min.X = bounds.Min.X + edge
+		case !uncovering : rect.Min.X = bounds.Max.X - edge
+		default          : rect.Max.X = bounds.Max.X - edge
+		}
+		utils.FillOverRect(canvas, rect, TransitionRGB)
+	default:
+		panic("invalid TransitionKind")
+	}
+}
+
+// Covers the canvas pixels whose dissolve threshold is below
+// the given coverage. Thresholds depend on the canvas pixel
+// position, so the pattern stays fixed on screen.
+func (self *RoomTransition) drawDissolve(canvas *ebiten.Image, coverage float64) {
+	bounds := canvas.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if self.mask == nil || self.mask.Bounds().Dx() != width || self.mask.Bounds().Dy() != height {
+		if self.mask != nil { self.mask.Deallocate() }
+		self.mask = ebiten.NewImage(width, height)
+		self.maskPixels = make([]byte, width*height*4)
+	}
+	clr := TransitionRGB
+	for y := range height {
+		for x := range width {
+			i := (y*width + x)*4
+			if dissolveThreshold(x, y) < coverage {
+				self.maskPixels[i + 0], self.maskPixels[i + 1] = clr.R, clr.G
+				self.maskPixels[i + 2], self.maskPixels[i + 3] = clr.B, clr.A
+			} else {
+				self.maskPixels[i + 0], self.maskPixels[i + 1] = 0, 0
+				self.maskPixels[i + 2], self.maskPixels[i + 3] = 0, 0
+			}
+		}
+	}
+	self.mask.WritePixels(self.maskPixels)
+	var opts ebiten.DrawImageOptions
+	opts.GeoM.Translate(float64(bounds.Min.X), float64(bounds.Min.Y))
+	canvas.DrawImage(self.mask, &opts)
+}
+
+// Returns a pseudo-random value in [0, 1) for the given
+// pixel coordinates.
+func dissolveThreshold(x, y int) float64 {
+	hash := uint32(x)*0x8DA6B343 ^ uint32(y)*0xD8163841
+	hash ^= hash >> 15
+	hash *= 0x2C1B3C6D
+	hash ^= hash >> 12
+	hash *= 0x297A2D39
+	hash ^= hash >> 15
+	return float64(hash)/(1 << 32)
+}
+
+// Scales a premultiplied alpha color by the given factor,
+// between 0 and 1.
+func scaleAlpha(clr color.RGBA, factor float64) color.RGBA {
+	scale := func(value uint8) uint8 { return uint8(math.Round(float64(value)*factor)) }
+	return color.RGBA{ scale(clr.R), scale(clr.G), scale(clr.B), scale(clr.A) }
+}
@@ -0,0 +1,224 @@
+package main
+
+import "fmt"
+import "image"
+
+import "github.com/tinne26/mipix"
+import "github.com/tinne26/mipix/utils"
+import "github.com/hajimehoshi/ebiten/v2"
+
+var UIBoxRGB    = utils.RGB(40, 28, 36)
+var UIBorderRGB = utils.RGB(244, 232, 232)
+var UITextRGB   = utils.RGB(244, 232, 232)
+var UIAccentRGB = utils.RGB(255, 22, 84)
+
+// UI layout and timing parameters, in logical pixels and ticks.
+const (
+	UIMargin = 6 // distance from the boxes to the screen edges
+	UIPadding = 4 // distance from the box borders to the text
+	UIChoiceGap = 3 // extra space between dialogue text and choices
+	UITypewriterTicks = 2 // ticks per revealed character
+	UISignTriggerMargin = 10 // horizontal trigger distance around signs
+	UIHintWidth = 16 // space reserved at the right of dialogue text for the confirm hint
+)
+
+// The UI draws the HUD and dialogue boxes at logical resolution
+// into an offscreen, which is then projected to the high resolution
+// canvas. This keeps the UI pixel-perfect and fixed on screen, no
+// matter the camera zoom, position or shakes.
+//
+// Dialogues are opened when the player walks near a sign, and
+// they pause the gameplay until closed.
+type UI struct {
+	offscreen *mipix.Offscreen
+	dialogue *Dialogue
+	node *DialogueNode // nil when no dialogue is open
+	lines []string // wrapped text of the current node
+	revealed int // number of characters revealed on the current node
+	revealTicks int
+	choice int
+	triggers []image.Rectangle
+	triggerArmed bool // the player must leave the trigger before reopening
+}
+
+func NewUI(level *Level, dialogue *Dialogue) *UI {
+	ui := &UI{
+		offscreen: mipix.NewOffscreen(GameWidth, GameHeight),
+		dialogue: dialogue,
+		triggerArmed: true,
+	}
+	ui.SetTriggers(level)
+	return ui
+}
+
+// Sets the dialogue trigger areas from the signs of the level.
+func (self *UI) SetTriggers(level *Level) {
+	self.triggers = self.triggers[ : 0]
+	for _, layer := range [][]Graphic{ level.Back, level.Front } {
+		for _, graphic := range layer {
+			if graphic.Name != "right_sign" { continue }
+			trigger := graphic.Bounds()
+			trigger.Min.X -= UISignTriggerMargin
+			trigger.Max.X += UISignTriggerMargin
+			self.triggers = append(self.triggers, trigger)
+		}
+	}
+}
+
+// Closes any open dialogue and sets the trigger areas from
+// the given level, as when the UI was created.
+func (self *UI) Reset(level *Level) {
+	self.Close()
+	self.triggerArmed = true
+	self.SetTriggers(level)
+}
+
+// Returns whether a dialogue is open.
+func (self *UI) IsBlocking() bool {
+	return self.node != nil
+}
+
+// Opens the dialogue at the given node, or closes it if the
+// node is [DialogueEnd] or doesn't exist.
+func (self *UI) Open(id string) {
+	self.node = self.dialogue.GetNode(id)
+	self.lines = nil
+	self.revealed, self.revealTicks, self.choice = 0, 0, 0
+	if self.node == nil { return }
+	textWidth := GameWidth - UIMargin*2 - 2 - UIPadding*2 - UIHintWidth
+	self.lines = wrapText(self.node.Text, textWidth)
+}
+
+func (self *UI) Close() {
+	self.Open(DialogueEnd)
+}
+
+// Opens dialogues when the player reaches a trigger, and
+// advances the open dialogue, if any.
+func (self *UI) Update(player *Player, input *Input) {
+	if self.node == nil {
+		near := false
+		for _, trigger := range self.triggers {
+			if !player.IsDead() && player.body.Overlaps(trigger) { near = true }
+		}
+		if near && self.triggerArmed { self.Open(self.dialogue.GetStart()) }
+		self.triggerArmed = !near
+		return
+	}
+
+	// typewriter reveal
+	total := self.totalChars()
+	for range mipix.Tick().GetRate() {
+		self.revealTicks += 1
+		if self.revealTicks >= UITypewriterTicks {
+			self.revealed, self.revealTicks = min(self.revealed + 1, total), 0
+		}
+	}
+	confirm := input.JustPressed(ActionInteract)
+	if self.revealed < total {
+		if confirm { self.revealed = total }
+		return
+	}
+
+	// choices and confirmation
+	numChoices := len(self.node.Choices)
+	if numChoices > 0 {
+		if input.JustPressed(ActionUp) {
+			self.choice = (self.choice + numChoices - 1) % numChoices
+		} else if input.JustPressed(ActionDown) {
+			self.choice = (self.choice + 1) % numChoices
+		}
+	}
+	if confirm {
+		if numChoices > 0 {
+			self.Open(self.node.Choices[self.choice].Next)
+		} else {
+			self.Open(self.node.Next)
+		}
+	}
+}
+
+func (self *UI) totalChars() int {
+	var total int
+	for _, line := range self.lines { total += len([]rune(line)) }
+	return total
+}
+
+// Draws the HUD and dialogue into the offscreen. The result
+// must be projected later with [UI.DrawHiRes]().
+func (self *UI) Draw(player *Player, input *Input) {
+	self.offscreen.Clear()
+	self.drawHUD(player)
+	if self.node != nil { self.drawDialogue(input) }
+}
+
+func (self *UI) DrawHiRes(target *ebiten.Image) {
+	self.offscreen.Project(target)
+}
+
+func (self *UI) drawHUD(player *Player) {
+	lines := []string{ fmt.Sprintf("DEATHS %d", player.GetDeaths()) }
+	if item := player.GetItem(); item != nil {
+		lines = append(lines, "ITEM " + item.Name)
+	}
+	width := 0
+	for _, line := range lines { width = max(width, measureText(line)) }
+	height := len(lines)*FontLineHeight - (FontLineHeight - FontGlyphHeight)
+	maxX, minY := GameWidth - UIMargin, UIMargin
+	box := image.Rect(maxX - width - UIPadding*2 - 2, minY, maxX, minY + height + UIPadding*2 + 2)
+	self.drawBox(box)
+	for i, line := range lines {
+		drawText(self.offscreen.Target(), line, box.Min.X + 1 + UIPadding, box.Min.Y + 1 + UIPadding + i*FontLineHeight, UITextRGB)
+	}
+}
+
+func (self *UI) drawDialogue(input *Input) {
+	// compute box size
+	total := self.totalChars()
+	height := len(self.lines)*FontLineHeight - (FontLineHeight - FontGlyphHeight)
+	showChoices := self.revealed >= total && len(self.node.Choices) > 0
+	if showChoices {
+		height += UIChoiceGap + len(self.node.Choices)*FontLineHeight
+	}
+	maxY := GameHeight - UIMargin
+	box := image.Rect(UIMargin, maxY - height - UIPadding*2 - 2, GameWidth - UIMargin, maxY)
+	self.drawBox(box)
+
+	// draw revealed text
+	target := self.offscreen.Target()
+	x, y := box.Min.X + 1 + UIPadding, box.Min.Y + 1 + UIPadding
+	left := self.revealed
+	for _, line := range self.lines {
+		runes := []rune(line)
+		drawText(target, string(runes[ : min(left, len(runes))]), x, y, UITextRGB)
+		left = max(left - len(runes), 0)
+		y += FontLineHeight
+	}
+
+	// draw choices or the blinking confirm hint
+	if showChoices {
+		y += UIChoiceGap - (FontLineHeight - FontGlyphHeight)
+		for i, choice := range self.node.Choices {
+			if i == self.choice {
+				drawText(target, "> " + choice.Text, x, y, UIAccentRGB)
+			} else {
+				drawText(target, "  " + choice.Text, x, y, UITextRGB)
+			}
+			y += FontLineHeight
+		}
+	} else if self.revealed >= total && (mipix.Tick().Now()/30) % 2 == 0 {
+		hint := "[" + input.KeyLabel(ActionInteract) + "]"
+		hintX := box.Max.X - 1 - UIPadding - measureText(hint)
+		drawText(target, hint, hintX, y - FontLineHeight, UIAccentRGB)
+	}
+}
+
+// Draws a filled box with a 1px border and cut corners.
+func (self *UI) drawBox(rect image.Rectangle) {
+	minX, minY, maxX, maxY := rect.Min.X, rect.Min.Y, rect.Max.X, rect.Max.Y
+	self.offscreen.CoatRect(image.Rect(minX + 1, minY, maxX - 1, minY + 1), UIBorderRGB)
+	self.offscreen.CoatRect(image.Rect(minX + 1, maxY - 1, maxX - 1, maxY), UIBorderRGB)
+	self.offscreen.CoatRect(image.Rect(minX, minY + 1, minX + 1, maxY - 1), UIBorderRGB)
+	self.offscreen.CoatRect(image.Rect(maxX - 1, minY + 1, maxX, maxY - 1), UIBorderRGB)
+	self.offscreen.CoatRect(rect.Inset(1), UIBoxRGB)
+}